@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Results of the latest pipeline run, served until the next run replaces them
+type Snapshot struct {
+	Results     []ContainerResult
+	ImageTagMap ImageTagMap
+	Started     time.Time
+	Duration    time.Duration
+}
+
+type containersResponse struct {
+	Report
+	LastRun time.Time `json:"lastRun"`
+}
+
+type repositoryHealth struct {
+	Repository      string  `json:"repository"`
+	Status          int     `json:"status"`
+	Tags            int     `json:"tags"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+type registryHealth struct {
+	Domain       string             `json:"domain"`
+	Healthy      bool               `json:"healthy"`
+	Errors       int                `json:"errors"`
+	Repositories []repositoryHealth `json:"repositories"`
+}
+
+type registriesResponse struct {
+	Registries []registryHealth `json:"registries"`
+	LastRun    time.Time        `json:"lastRun"`
+}
+
+type checkResponse struct {
+	Queued bool `json:"queued"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Serves the results of the latest run. Requests never trigger registry
+// fetches themselves, a re-check is queued for the run loop instead.
+type Server struct {
+	mutex    sync.RWMutex
+	snapshot *Snapshot
+	checks   chan struct{}
+}
+
+func NewServer() *Server {
+	return &Server{checks: make(chan struct{}, 1)}
+}
+
+func (s *Server) Update(snapshot Snapshot) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.snapshot = &snapshot
+}
+
+// Receives a value for every re-check requested through the API
+func (s *Server) Checks() <-chan struct{} {
+	return s.checks
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/containers", s.listContainers)
+	// Container names can contain slashes, like kubernetes namespace/pod/container
+	mux.HandleFunc("GET /api/containers/{name...}", s.getContainer)
+	mux.HandleFunc("POST /api/check", s.check)
+	mux.HandleFunc("GET /api/registries", s.listRegistries)
+	return mux
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// Returns the current snapshot, or writes an error if no run has finished yet
+func (s *Server) current(w http.ResponseWriter) *Snapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.snapshot == nil {
+		writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "no run finished yet"})
+	}
+	return s.snapshot
+}
+
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.current(w)
+	if snapshot == nil {
+		return
+	}
+	results := snapshot.Results
+	if results == nil {
+		results = []ContainerResult{}
+	}
+	writeJson(w, http.StatusOK, containersResponse{
+		Report:  Report{SchemaVersion: ReportSchemaVersion, Containers: results},
+		LastRun: snapshot.Started,
+	})
+}
+
+func (s *Server) getContainer(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.current(w)
+	if snapshot == nil {
+		return
+	}
+	// Containers with the same name on several hosts are told apart by ?host=
+	name := r.PathValue("name")
+	host := r.URL.Query().Get("host")
+	for _, result := range snapshot.Results {
+		if result.Container == name && (host == "" || result.Host == host) {
+			writeJson(w, http.StatusOK, result)
+			return
+		}
+	}
+	writeJson(w, http.StatusNotFound, errorResponse{Error: "container not found"})
+}
+
+func (s *Server) check(w http.ResponseWriter, r *http.Request) {
+	// A re-check that is already queued covers this request too
+	select {
+	case s.checks <- struct{}{}:
+	default:
+	}
+	writeJson(w, http.StatusAccepted, checkResponse{Queued: true})
+}
+
+func (s *Server) listRegistries(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.current(w)
+	if snapshot == nil {
+		return
+	}
+
+	domainHealth := make(map[string]*registryHealth)
+	for _, repository := range slices.Sorted(maps.Keys(snapshot.ImageTagMap)) {
+		imageTags := snapshot.ImageTagMap[repository]
+		domain, _, _ := strings.Cut(repository, "/")
+		health, ok := domainHealth[domain]
+		if !ok {
+			health = &registryHealth{Domain: domain, Healthy: true, Repositories: []repositoryHealth{}}
+			domainHealth[domain] = health
+		}
+		if imageTags.Err != nil {
+			health.Healthy = false
+			health.Errors++
+		}
+		repositoryHealth := repositoryHealth{
+			Repository:      repository,
+			Status:          imageTags.Status,
+			Tags:            len(imageTags.Tags),
+			DurationSeconds: imageTags.Duration.Seconds(),
+		}
+		if imageTags.Err != nil {
+			repositoryHealth.Error = imageTags.Err.Error()
+		}
+		health.Repositories = append(health.Repositories, repositoryHealth)
+	}
+
+	registries := []registryHealth{}
+	for _, domain := range slices.Sorted(maps.Keys(domainHealth)) {
+		registries = append(registries, *domainHealth[domain])
+	}
+	writeJson(w, http.StatusOK, registriesResponse{Registries: registries, LastRun: snapshot.Started})
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of the cache file format, entries with another version are ignored
+const fileVersion = 2
+
+type Entry struct {
+	Version   int       `json:"version"`
+	Tags      []string  `json:"tags"`
+	ETag      string    `json:"etag,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Platforms []string  `json:"platforms,omitempty"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// Tag lists stored on disk by <domain>/<path>. A cache without a directory
+// is disabled and never returns or stores anything.
+type Cache struct {
+	dir     string
+	ttl     time.Duration
+	refresh bool
+}
+
+// Creates a cache in dir. With refresh set cached entries are never used,
+// but fetched tags are still stored.
+func New(dir string, ttl time.Duration, refresh bool) *Cache {
+	return &Cache{dir: dir, ttl: ttl, refresh: refresh}
+}
+
+func (c *Cache) Enabled() bool {
+	return c.dir != ""
+}
+
+func (c *Cache) entryPath(key string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(key)+".json")
+}
+
+// Returns the cached entry for the key, if there is a usable one
+func (c *Cache) Get(key string) (Entry, bool, error) {
+	if !c.Enabled() || c.refresh {
+		return Entry{}, false, nil
+	}
+
+	data, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Entry{}, false, nil
+		}
+		return Entry{}, false, err
+	}
+	entry := Entry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, false, fmt.Errorf("parsing cache entry %s: %w", key, err)
+	}
+	if entry.Version != fileVersion {
+		return Entry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+// Returns whether the entry can be used without asking the registry
+func (c *Cache) Fresh(entry Entry, now time.Time) bool {
+	return now.Sub(entry.FetchedAt) < c.ttl
+}
+
+// Stores the entry for the key, replacing the file atomically
+func (c *Cache) Put(key string, entry Entry) error {
+	if !c.Enabled() {
+		return nil
+	}
+	entry.Version = fileVersion
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -2,9 +2,11 @@ package command
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"slices"
 	"strings"
+	"time"
 
 	flag "github.com/spf13/pflag"
 
@@ -25,27 +27,62 @@ func (i multiValueFlags) Has(s string) bool {
 
 func SetupCommandline() (CommandFlags, multiValueFlags) {
 	cmdFlags := CommandFlags{
-		ConfigPathPtr: flag.StringP("config", "f", "config.yaml", "Specify config file path"),
-		DebugPtr:      flag.BoolP("debug", "d", false, "Enable debug output"),
-		MockPtr:       flag.String("mock", "none", "Enable mocks (none, config, containers, registry, all)"),
-		ColumnsPtr:    flag.StringP("columns", "c", "", "Set columns to use for output. See COLUMNSPEC"),
-		HostPtr:       flag.StringP("host", "h", "unix:///var/run/docker/docker.sock", "Set docker/podman host"),
-		IncludeAllPtr: flag.BoolP("include-all", "a", false, "Include stopped containers"),
-		NoProgressPtr: flag.BoolP("no-progress", "n", false, "Hide progress bar"),
-		VersionPtr:    flag.Bool("version", false, "Print version information and exit"),
-		HelpPtr:       flag.Bool("help", false, "Print Help (this message) and exit"),
+		ConfigPathPtr:          flag.StringP("config", "f", "config.yaml", "Specify config file path"),
+		DebugPtr:               flag.BoolP("debug", "d", false, "Enable debug output"),
+		MockPtr:                flag.String("mock", "none", "Enable mocks (none, config, containers, registry, all)"),
+		ColumnsPtr:             flag.StringP("columns", "c", "", "Set columns to use for output. See COLUMNSPEC"),
+		HostPtr:                flag.StringP("host", "h", "unix:///var/run/docker/docker.sock", "Set docker/podman host"),
+		IncludeAllPtr:          flag.BoolP("include-all", "a", false, "Include stopped containers"),
+		NoProgressPtr:          flag.BoolP("no-progress", "n", false, "Hide progress bar"),
+		ConcurrencyPtr:         flag.IntP("concurrency", "j", 8, "Maximum number of concurrent tag fetches"),
+		RegistryConcurrencyPtr: flag.Int("registry-concurrency", 4, "Maximum number of concurrent tag fetches per registry"),
+		OutputPtr:              flag.StringP("output", "o", "table", "Set output format (table, json, yaml, csv)"),
+		DiscoveryPtr:           flag.String("discovery", "docker", "Set container discovery source (docker, compose, kubernetes, dockerfile)"),
+		DiscoveryPathsPtr:      flag.StringSliceP("path", "p", []string{}, "Set files to use for discovery, can be repeated"),
+		IntervalPtr:            flag.Duration("interval", 6*time.Hour, "Set time between checks in watch mode"),
+		CronPtr:                flag.String("cron", "", "Set cron expression for checks in watch mode, overrides --interval"),
+		StateFilePtr:           flag.String("state", "", "Set state file path used to track reported updates"),
+		OnlyNewPtr:             flag.Bool("only-new", false, "Only show updates that were not reported before"),
+		MetricsListenPtr:       flag.String("metrics-listen", "", "Set address to serve Prometheus metrics on in watch mode, like :9090"),
+		MetricsFilePtr:         flag.String("metrics-file", "", "Set node_exporter textfile path to write Prometheus metrics to"),
+		ListenPtr:              flag.String("listen", ":8080", "Set address to serve the API on in serve mode"),
+		CacheDirPtr:            flag.String("cache-dir", "", "Set directory to cache tag lists in, disabled when empty"),
+		CacheTtlPtr:            flag.Duration("cache-ttl", time.Hour, "Set time cached tag lists are used before asking the registry again"),
+		RefreshPtr:             flag.Bool("refresh", false, "Ignore cached tag lists and fetch them again"),
+		MinRateLimitPtr:        flag.Int("min-rate-limit", 0, "Stop fetching from a registry when its remaining rate limit is at or below this"),
+		PageSizePtr:            flag.Int("page-size", 1000, "Set number of tags to ask for per page"),
+		MaxPagesPtr:            flag.Int("max-pages", 100, "Set maximum number of tag pages to fetch per repository, 0 for unlimited"),
+		FailOnPtr:              flag.String("fail-on", "none", "Exit with a non-zero code on (none, updates, errors, any)"),
+		VersionPtr:             flag.Bool("version", false, "Print version information and exit"),
+		HelpPtr:                flag.Bool("help", false, "Print Help (this message) and exit"),
 	}
 	flag.CommandLine.SortFlags = false
 	flag.CommandLine.MarkHidden("mock")
-	flag.Parse()
+	// Exit code 2 means updates, so parse errors must not use the pflag default
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		log.Printf("%v, see --help", err)
+		os.Exit(ExitFatal)
+	}
+
+	command := Commands[0]
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+	}
+	cmdFlags.CommandPtr = &command
 
 	if *cmdFlags.HelpPtr {
-		fmt.Println("Usage: contrack [OPTION]")
+		fmt.Println("Usage: contrack [COMMAND] [OPTION]")
+		fmt.Println("\nCommands:")
+		fmt.Println("  check                Check containers once and exit (default)")
+		fmt.Println("  watch                Keep running and check containers on a schedule")
+		fmt.Println("  serve                Like watch, and serve the results over an HTTP API")
 		fmt.Println("\nOptions:")
 		flag.CommandLine.PrintDefaults()
 		fmt.Println("\nCOLUMNSPEC:")
 		fmt.Println("A comma separated line of column names")
 		fmt.Println("  container            The container name")
+		fmt.Println("  host                 Name of the configured host running the container")
 		fmt.Println("  status               Short processing status (OK/ERR)")
 		fmt.Println("  detail               Long processing status error explaination")
 		fmt.Println("  repository           Repository (<domain>/<path>)")
@@ -53,7 +90,16 @@ func SetupCommandline() (CommandFlags, multiValueFlags) {
 		fmt.Println("  domain               Image domain")
 		fmt.Println("  path                 Image path")
 		fmt.Println("  tag                  Image tag")
-		fmt.Println("  update               Newer tag found")
+		fmt.Println("  update               Newer tag found, allowed by the update policy")
+		fmt.Println("  latest               Newest tag found, regardless of update policy")
+		fmt.Println("  platform             Platform updates must be available for")
+		fmt.Println("  digest               Image digest, used to check floating tags")
+		fmt.Println("  age                  Time since the update was first found (needs --state)")
+		fmt.Println("\nEXIT CODES:")
+		fmt.Printf("  %d                    All containers up to date (or condition not selected by --fail-on)\n", ExitOk)
+		fmt.Printf("  %d                    Fatal command line, configuration or discovery failure\n", ExitFatal)
+		fmt.Printf("  %d                    Updates available (--fail-on updates/any)\n", ExitUpdates)
+		fmt.Printf("  %d                    Some containers errored (--fail-on errors/any)\n", ExitErrors)
 		os.Exit(0)
 	}
 
@@ -69,3 +115,34 @@ func SetupCommandline() (CommandFlags, multiValueFlags) {
 
 	return cmdFlags, mockFlags
 }
+
+// Conditions that can be selected with --fail-on
+var FailOnConditions = []string{"none", "updates", "errors", "any"}
+
+// Decides the process exit code from the processed results. Errors take
+// precedence over updates when both are selected and present.
+func ExitCode(config Config, results []ContainerResult) int {
+	hasUpdates := false
+	hasErrors := false
+	for _, result := range results {
+		if result.Status != "OK" {
+			hasErrors = true
+		}
+		if result.Update != "" {
+			hasUpdates = true
+		}
+	}
+
+	failOnUpdates := config.FailOn == "updates" || config.FailOn == "any"
+	failOnErrors := config.FailOn == "errors" || config.FailOn == "any"
+	if failOnErrors && hasErrors {
+		return ExitErrors
+	}
+	if failOnUpdates && hasUpdates {
+		return ExitUpdates
+	}
+	return ExitOk
+}
+
+// Available commands, the first is the default
+var Commands = []string{"check", "watch", "serve"}
@@ -5,10 +5,20 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"maps"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/mlofjard/contrack/command"
+	"github.com/mlofjard/contrack/containers"
+	"github.com/mlofjard/contrack/notify"
+	"github.com/mlofjard/contrack/output"
 	"github.com/mlofjard/contrack/registry"
+	"github.com/mlofjard/contrack/schedule"
+	"github.com/mlofjard/contrack/tlsconfig"
 	. "github.com/mlofjard/contrack/types"
 
 	flag "github.com/spf13/pflag"
@@ -16,33 +26,107 @@ import (
 )
 
 type configRegistry struct {
-	Domain string  `yaml:"domain"`
-	Auth   *string `yaml:"auth"`
-	Token  *string `yaml:"token"`
-	Url    *string `yaml:"url"`
+	Domain      string         `yaml:"domain"`
+	Auth        *string        `yaml:"auth"`
+	Token       *string        `yaml:"token"`
+	Url         *string        `yaml:"url"`
+	Concurrency *int           `yaml:"concurrency"`
+	Credentials *string        `yaml:"credentials"`
+	TLS         *configTLS     `yaml:"tls"`
+	Scheme      *string        `yaml:"scheme"`
+	Insecure    *bool          `yaml:"insecure"`
+	Mirrors     []configMirror `yaml:"mirrors"`
+}
+
+// Mirror given as a url, or as a mapping with url and tls
+type configMirror struct {
+	Url string     `yaml:"url"`
+	TLS *configTLS `yaml:"tls"`
+}
+
+func (m *configMirror) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&m.Url)
+	}
+	type plain configMirror
+	return value.Decode((*plain)(m))
+}
+
+type configTLS struct {
+	CA                 string `yaml:"ca"`
+	Cert               string `yaml:"cert"`
+	Key                string `yaml:"key"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	ServerName         string `yaml:"serverName"`
+}
+
+func (t *configTLS) tlsConfig() TLSConfig {
+	if t == nil {
+		return TLSConfig{}
+	}
+	return TLSConfig{CA: t.CA, Cert: t.Cert, Key: t.Key, InsecureSkipVerify: t.InsecureSkipVerify, ServerName: t.ServerName}
+}
+
+type configHost struct {
+	Name     string     `yaml:"name"`
+	Endpoint string     `yaml:"endpoint"`
+	TLS      *configTLS `yaml:"tls"`
+}
+
+type configNotifier struct {
+	Url      string            `yaml:"url"`
+	Method   *string           `yaml:"method"`
+	Headers  map[string]string `yaml:"headers"`
+	Format   *string           `yaml:"format"`
+	Template *string           `yaml:"template"`
 }
 
 type configFile struct {
 	Host           *string                   `yaml:"host"`
+	Hosts          []configHost              `yaml:"hosts"`
 	Debug          *bool                     `yaml:"debug"`
 	IncludeStopped *bool                     `yaml:"includeStopped"`
 	NoProgress     *bool                     `yaml:"noProgress"`
 	Registries     map[string]configRegistry `yaml:"registries"`
 	Columns        *[]string                 `yaml:"columns"`
+	Concurrency    *int                      `yaml:"concurrency"`
+	RegistryConc   *int                      `yaml:"registryConcurrency"`
+	Output         *string                   `yaml:"output"`
+	FailOn         *string                   `yaml:"failOn"`
+	Discovery      *string                   `yaml:"discovery"`
+	DiscoveryPaths *[]string                 `yaml:"discoveryPaths"`
+	Interval       *time.Duration            `yaml:"interval"`
+	Cron           *string                   `yaml:"cron"`
+	Notifiers      map[string]configNotifier `yaml:"notifiers"`
+	StateFile      *string                   `yaml:"stateFile"`
+	OnlyNew        *bool                     `yaml:"onlyNew"`
+	MetricsListen  *string                   `yaml:"metricsListen"`
+	MetricsFile    *string                   `yaml:"metricsFile"`
+	Listen         *string                   `yaml:"listen"`
+	CacheDir       *string                   `yaml:"cacheDir"`
+	CacheTtl       *time.Duration            `yaml:"cacheTtl"`
+	MinRateLimit   *int                      `yaml:"minRateLimit"`
+	PageSize       *int                      `yaml:"pageSize"`
+	MaxPages       *int                      `yaml:"maxPages"`
 }
 
-func FileReaderFunc(cmdFlags *CommandFlags) []byte {
+func FileReaderFunc(cmdFlags *CommandFlags) ([]byte, error) {
 	data, err := os.ReadFile(*cmdFlags.ConfigPathPtr)
 	if err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			log.Fatalf("Error reading config file: %v", err)
+		// Running without a config file uses the defaults
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
 		}
+		return nil, NewError(ErrorKinds.Parse, "Error reading config file", err)
 	}
-	return data
+	return data, nil
 }
 
-func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainConfiguredRegistryMap, fileReaderFn ConfigFileReaderFn) Config {
-	data := fileReaderFn(cmdFlags)
+func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainConfiguredRegistryMap, fileReaderFn ConfigFileReaderFn) (Config, error) {
+	data, err := fileReaderFn(cmdFlags)
+	if err != nil {
+		return Config{}, err
+	}
 	debug := func(a ...any) {
 		if *cmdFlags.DebugPtr {
 			fmt.Print("CONFIG ")
@@ -51,20 +135,31 @@ func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainC
 	}
 
 	// Create object for unmarshalling our YAML
-	configFile := configFile{Registries: make(map[string]configRegistry)}
+	configFile := configFile{Registries: make(map[string]configRegistry), Notifiers: make(map[string]configNotifier)}
 
 	// Unmarshal YAML data
-	err := yaml.Unmarshal([]byte(data), &configFile)
+	err = yaml.Unmarshal([]byte(data), &configFile)
 	if err != nil {
-		log.Fatalf("Error parsing config file: %v", err)
+		return Config{}, NewError(ErrorKinds.Parse, "Error parsing config file", err)
 	}
 
 	// Default values
 	config := Config{
-		Debug:      false,
-		NoProgress: false,
-		Host:       "unix:///var/run/docker/docker.sock",
-		Columns:    []string{"status", "container", "repository", "tag", "update"},
+		Debug:               false,
+		NoProgress:          false,
+		Host:                "unix:///var/run/docker/docker.sock",
+		Columns:             []string{"status", "container", "repository", "tag", "update"},
+		Concurrency:         8,
+		RegistryConcurrency: 4,
+		Output:              "table",
+		FailOn:              "none",
+		Discovery:           "docker",
+		Command:             *cmdFlags.CommandPtr,
+		Interval:            6 * time.Hour,
+		Listen:              ":8080",
+		CacheTtl:            time.Hour,
+		PageSize:            1000,
+		MaxPages:            100,
 	}
 
 	// Override from config
@@ -80,6 +175,25 @@ func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainC
 		debug("Found Host in config file")
 		config.Host = *configFile.Host
 	}
+	for idx, configHost := range configFile.Hosts {
+		debug("Found Host", configHost.Name, "in config file")
+		if configHost.Endpoint == "" {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Host %d is missing endpoint", idx+1), nil)
+		}
+		host := DockerHost{Name: configHost.Name, Endpoint: configHost.Endpoint}
+		if host.Name == "" {
+			host.Name = host.Endpoint
+		}
+		if slices.ContainsFunc(config.Hosts, func(h DockerHost) bool { return h.Name == host.Name }) {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Host name %s is used more than once", host.Name), nil)
+		}
+		host.TLS = configHost.TLS.tlsConfig()
+		// Certificates are loaded again by the docker client, this reports bad paths early
+		if _, err := tlsconfig.Client(host.TLS); err != nil {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Invalid tls for host %s", host.Name), err)
+		}
+		config.Hosts = append(config.Hosts, host)
+	}
 	if configFile.IncludeStopped != nil {
 		debug("Found IncludeAll in config file")
 		config.IncludeAll = *configFile.IncludeStopped
@@ -88,6 +202,78 @@ func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainC
 		debug("Found Columns in config file")
 		config.Columns = *configFile.Columns
 	}
+	if configFile.Concurrency != nil {
+		debug("Found Concurrency in config file")
+		config.Concurrency = *configFile.Concurrency
+	}
+	if configFile.RegistryConc != nil {
+		debug("Found RegistryConcurrency in config file")
+		config.RegistryConcurrency = *configFile.RegistryConc
+	}
+	if configFile.Output != nil {
+		debug("Found Output in config file")
+		config.Output = *configFile.Output
+	}
+	if configFile.FailOn != nil {
+		debug("Found FailOn in config file")
+		config.FailOn = *configFile.FailOn
+	}
+	if configFile.Discovery != nil {
+		debug("Found Discovery in config file")
+		config.Discovery = *configFile.Discovery
+	}
+	if configFile.DiscoveryPaths != nil {
+		debug("Found DiscoveryPaths in config file")
+		config.DiscoveryPaths = *configFile.DiscoveryPaths
+	}
+	if configFile.Interval != nil {
+		debug("Found Interval in config file")
+		config.Interval = *configFile.Interval
+	}
+	if configFile.Cron != nil {
+		debug("Found Cron in config file")
+		config.Cron = *configFile.Cron
+	}
+	if configFile.StateFile != nil {
+		debug("Found StateFile in config file")
+		config.StateFile = *configFile.StateFile
+	}
+	if configFile.OnlyNew != nil {
+		debug("Found OnlyNew in config file")
+		config.OnlyNew = *configFile.OnlyNew
+	}
+	if configFile.MetricsListen != nil {
+		debug("Found MetricsListen in config file")
+		config.MetricsListen = *configFile.MetricsListen
+	}
+	if configFile.MetricsFile != nil {
+		debug("Found MetricsFile in config file")
+		config.MetricsFile = *configFile.MetricsFile
+	}
+	if configFile.Listen != nil {
+		debug("Found Listen in config file")
+		config.Listen = *configFile.Listen
+	}
+	if configFile.CacheDir != nil {
+		debug("Found CacheDir in config file")
+		config.CacheDir = *configFile.CacheDir
+	}
+	if configFile.CacheTtl != nil {
+		debug("Found CacheTtl in config file")
+		config.CacheTtl = *configFile.CacheTtl
+	}
+	if configFile.MinRateLimit != nil {
+		debug("Found MinRateLimit in config file")
+		config.MinRateLimit = *configFile.MinRateLimit
+	}
+	if configFile.PageSize != nil {
+		debug("Found PageSize in config file")
+		config.PageSize = *configFile.PageSize
+	}
+	if configFile.MaxPages != nil {
+		debug("Found MaxPages in config file")
+		config.MaxPages = *configFile.MaxPages
+	}
 
 	// Override from flags
 	flag.Visit(func(f *flag.Flag) {
@@ -99,22 +285,110 @@ func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainC
 		case "no-progress":
 			config.NoProgress = *cmdFlags.NoProgressPtr
 		case "host":
+			// A host given on the command line replaces the configured hosts
 			config.Host = *cmdFlags.HostPtr
+			config.Hosts = nil
 		case "columns":
 			config.Columns = strings.Split(*cmdFlags.ColumnsPtr, ",")
+		case "concurrency":
+			config.Concurrency = *cmdFlags.ConcurrencyPtr
+		case "registry-concurrency":
+			config.RegistryConcurrency = *cmdFlags.RegistryConcurrencyPtr
+		case "output":
+			config.Output = *cmdFlags.OutputPtr
+		case "fail-on":
+			config.FailOn = *cmdFlags.FailOnPtr
+		case "discovery":
+			config.Discovery = *cmdFlags.DiscoveryPtr
+		case "path":
+			config.DiscoveryPaths = *cmdFlags.DiscoveryPathsPtr
+		case "interval":
+			config.Interval = *cmdFlags.IntervalPtr
+		case "cron":
+			config.Cron = *cmdFlags.CronPtr
+		case "state":
+			config.StateFile = *cmdFlags.StateFilePtr
+		case "only-new":
+			config.OnlyNew = *cmdFlags.OnlyNewPtr
+		case "metrics-listen":
+			config.MetricsListen = *cmdFlags.MetricsListenPtr
+		case "metrics-file":
+			config.MetricsFile = *cmdFlags.MetricsFilePtr
+		case "listen":
+			config.Listen = *cmdFlags.ListenPtr
+		case "cache-dir":
+			config.CacheDir = *cmdFlags.CacheDirPtr
+		case "cache-ttl":
+			config.CacheTtl = *cmdFlags.CacheTtlPtr
+		case "refresh":
+			config.Refresh = *cmdFlags.RefreshPtr
+		case "min-rate-limit":
+			config.MinRateLimit = *cmdFlags.MinRateLimitPtr
+		case "page-size":
+			config.PageSize = *cmdFlags.PageSizePtr
+		case "max-pages":
+			config.MaxPages = *cmdFlags.MaxPagesPtr
 		}
 	})
 
+	if !slices.Contains(output.Formats, config.Output) {
+		return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Unknown output format %q, expected one of %s", config.Output, strings.Join(output.Formats, ", ")), nil)
+	}
+	if !slices.Contains(command.FailOnConditions, config.FailOn) {
+		return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Unknown fail-on condition %q, expected one of %s", config.FailOn, strings.Join(command.FailOnConditions, ", ")), nil)
+	}
+	if _, ok := containers.DiscoveryFuncs[config.Discovery]; !ok {
+		return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Unknown discovery source %q, expected one of %s", config.Discovery, strings.Join(slices.Sorted(maps.Keys(containers.DiscoveryFuncs)), ", ")), nil)
+	}
+	if !slices.Contains(command.Commands, config.Command) {
+		return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Unknown command %q, expected one of %s", config.Command, strings.Join(command.Commands, ", ")), nil)
+	}
+	if config.Command == "watch" || config.Command == "serve" {
+		if _, err := schedule.New(config.Interval, config.Cron); err != nil {
+			return Config{}, NewError(ErrorKinds.Parse, "Invalid watch schedule", err)
+		}
+	}
+	// The progress bar would end up in machine readable output and logs
+	if config.Output != "table" || config.Command != "check" {
+		config.NoProgress = true
+	}
+
+	// Concurrency below one would never fetch anything
+	config.Concurrency = max(config.Concurrency, 1)
+	config.RegistryConcurrency = max(config.RegistryConcurrency, 1)
+
 	// Iterate over config and map registries
 	for registryName, configRegistry := range configFile.Registries {
 
-		normalizedUrl := configRegistry.Domain
 		if config.Debug {
-			fmt.Println(" ** Pre normalized url", normalizedUrl)
+			fmt.Println(" ** Pre normalized domain", configRegistry.Domain)
 		}
-		if strings.Index(configRegistry.Domain, "https://") == -1 {
-			normalizedUrl = fmt.Sprintf("https://%s/v2", configRegistry.Domain)
+		// A domain written as a url also sets the scheme
+		domain := configRegistry.Domain
+		scheme := "https"
+		if urlScheme, host, found := strings.Cut(domain, "://"); found {
+			scheme = strings.ToLower(urlScheme)
+			domain = host
 		}
+		domain = registry.NormalizeDomain(domain)
+		// A registry api below a path is configured with url instead
+		if strings.Contains(domain, "/") {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Domain %q of registry %s must not have a path, set url instead", configRegistry.Domain, registryName), nil)
+		}
+		if configRegistry.Scheme != nil {
+			scheme = strings.ToLower(*configRegistry.Scheme)
+		}
+		if configRegistry.Insecure != nil && *configRegistry.Insecure {
+			// Insecure registries speak plain http, another scheme contradicts that
+			if configRegistry.Scheme != nil && scheme != "http" {
+				return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Registry %s is insecure but has scheme %s", registryName, scheme), nil)
+			}
+			scheme = "http"
+		}
+		if scheme != "https" && scheme != "http" {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Unknown scheme %q for registry %s, expected http or https", scheme, registryName), nil)
+		}
+		normalizedUrl := fmt.Sprintf("%s://%s/v2", scheme, domain)
 
 		if config.Debug {
 			fmt.Println("cfgRepo auth", configRegistry.Auth)
@@ -143,7 +417,62 @@ func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainC
 			authToken = *configRegistry.Token
 		}
 
-		if reg, ok := registry.DomainRegistryMap[configRegistry.Domain]; !ok {
+		if configRegistry.Credentials != nil {
+			switch *configRegistry.Credentials {
+			case "docker-config":
+				credAuthType, credToken, authFile, err := dockerConfigCredentials(domain)
+				if err != nil {
+					log.Printf("No credentials for %s from docker config: %v", domain, err)
+				} else {
+					if config.Debug {
+						fmt.Println("credentials for", domain, "found in", authFile)
+					}
+					authType = credAuthType
+					authToken = credToken
+				}
+			default:
+				return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Unknown credentials source %q for registry %s, expected docker-config", *configRegistry.Credentials, registryName), nil)
+			}
+		}
+
+		tlsConfig, err := tlsconfig.Client(configRegistry.TLS.tlsConfig())
+		if err != nil {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Invalid tls for registry %s", registryName), err)
+		}
+
+		// Mirrors are given as base urls like https://mirror.example.com
+		mirrors := []ConfiguredRegistry{}
+		for _, configMirror := range configRegistry.Mirrors {
+			mirror := configMirror.Url
+			if !strings.Contains(mirror, "://") {
+				mirror = "https://" + mirror
+			}
+			mirrorUrl, err := url.Parse(strings.TrimRight(mirror, "/"))
+			if err != nil || mirrorUrl.Host == "" || mirrorUrl.Scheme != "https" && mirrorUrl.Scheme != "http" {
+				return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Invalid mirror %q for registry %s", mirror, registryName), err)
+			}
+			if !strings.HasSuffix(mirrorUrl.Path, "/v2") {
+				mirrorUrl.Path += "/v2"
+			}
+			mirrorTLS, err := tlsconfig.Client(configMirror.TLS.tlsConfig())
+			if err != nil {
+				return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Invalid tls for mirror %s of registry %s", mirror, registryName), err)
+			}
+			mirrors = append(mirrors, ConfiguredRegistry{
+				AuthType: AuthTypes.None,
+				Name:     registryName,
+				Registry: registry.Custom{RegistryUrl: mirrorUrl.String()},
+				Domain:   domain,
+				TLS:      mirrorTLS,
+			})
+		}
+
+		concurrency := config.RegistryConcurrency
+		if configRegistry.Concurrency != nil {
+			concurrency = max(*configRegistry.Concurrency, 1)
+		}
+
+		if reg, ok := registry.DomainRegistryMap[domain]; !ok {
 			// If domain is not found in the map, treat it like a custom registry
 
 			// Set normalizedUrl if not overridden from config
@@ -152,20 +481,26 @@ func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainC
 				registryUrl = *configRegistry.Url
 			}
 
-			domainConfiguredRegistryMap[configRegistry.Domain] = ConfiguredRegistry{
-				AuthType:  authType,
-				AuthToken: authToken,
-				Name:      registryName,
-				Registry:  registry.Custom{RegistryUrl: registryUrl},
-				Domain:    configRegistry.Domain,
+			domainConfiguredRegistryMap[domain] = ConfiguredRegistry{
+				AuthType:    authType,
+				AuthToken:   authToken,
+				Name:        registryName,
+				Registry:    registry.Custom{RegistryUrl: registryUrl},
+				Domain:      domain,
+				Concurrency: concurrency,
+				TLS:         tlsConfig,
+				Mirrors:     mirrors,
 			}
 		} else {
-			domainConfiguredRegistryMap[configRegistry.Domain] = ConfiguredRegistry{
-				AuthType:  authType,
-				AuthToken: authToken,
-				Name:      registryName,
-				Registry:  reg,
-				Domain:    configRegistry.Domain,
+			domainConfiguredRegistryMap[domain] = ConfiguredRegistry{
+				AuthType:    authType,
+				AuthToken:   authToken,
+				Name:        registryName,
+				Registry:    reg,
+				Domain:      domain,
+				Concurrency: concurrency,
+				TLS:         tlsConfig,
+				Mirrors:     mirrors,
 			}
 		}
 	}
@@ -174,5 +509,33 @@ func ParseConfigFile(cmdFlags *CommandFlags, domainConfiguredRegistryMap DomainC
 		fmt.Println("repo map", domainConfiguredRegistryMap)
 	}
 
-	return config
+	// Iterate over config and map notifiers, sorted so they run in a stable order
+	for _, notifierName := range slices.Sorted(maps.Keys(configFile.Notifiers)) {
+		configNotifier := configFile.Notifiers[notifierName]
+		if configNotifier.Url == "" {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Notifier %s is missing url", notifierName), nil)
+		}
+
+		notifier := NotifierConfig{Name: notifierName, Url: configNotifier.Url, Headers: configNotifier.Headers}
+		if configNotifier.Method != nil {
+			notifier.Method = strings.ToUpper(*configNotifier.Method)
+		}
+		if configNotifier.Format != nil {
+			if _, ok := notify.FormatTemplates[*configNotifier.Format]; !ok {
+				return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Unknown format %q for notifier %s, expected one of %s", *configNotifier.Format, notifierName, strings.Join(slices.Sorted(maps.Keys(notify.FormatTemplates)), ", ")), nil)
+			}
+			notifier.Format = *configNotifier.Format
+		}
+		if configNotifier.Template != nil {
+			notifier.Template = *configNotifier.Template
+		}
+		body, err := notify.ParseTemplate(notifier)
+		if err != nil {
+			return Config{}, NewError(ErrorKinds.Parse, fmt.Sprintf("Error parsing template for notifier %s", notifierName), err)
+		}
+		notifier.Body = body
+		config.Notifiers = append(config.Notifiers, notifier)
+	}
+
+	return config, nil
 }
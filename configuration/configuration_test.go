@@ -0,0 +1,109 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mlofjard/contrack/registry"
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Starts a plain http registry serving a tag list for team/app
+func newPlainRegistry(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/team/app/tags/list":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"name": "team/app", "tags": []string{"1.0.0", "1.1.0"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serverUrl.Host
+}
+
+func parseConfig(t *testing.T, yaml string) (Config, DomainConfiguredRegistryMap, error) {
+	t.Helper()
+	debug := false
+	command := "check"
+	cmdFlags := CommandFlags{DebugPtr: &debug, CommandPtr: &command}
+	domainConfiguredRegistryMap := make(DomainConfiguredRegistryMap)
+	reader := func(*CommandFlags) ([]byte, error) { return []byte(yaml), nil }
+	config, err := ParseConfigFile(&cmdFlags, domainConfiguredRegistryMap, reader)
+	return config, domainConfiguredRegistryMap, err
+}
+
+func TestPlainHttpRegistry(t *testing.T) {
+	host := newPlainRegistry(t)
+
+	tests := []struct {
+		name   string
+		option string
+	}{
+		{name: "scheme", option: "scheme: http"},
+		{name: "insecure", option: "insecure: true"},
+		{name: "insecure with scheme", option: "insecure: true\n    scheme: http"},
+		{name: "url domain", option: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			domain := host
+			if test.option == "" {
+				domain = "http://" + host + "/"
+			}
+			yaml := fmt.Sprintf("registries:\n  local:\n    domain: %s\n    %s\n", domain, test.option)
+			_, domainConfiguredRegistryMap, err := parseConfig(t, yaml)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			configuredRegistry, ok := domainConfiguredRegistryMap[host]
+			if !ok {
+				t.Fatalf("registry not configured for %s, got %v", host, domainConfiguredRegistryMap)
+			}
+			if got, want := configuredRegistry.Registry.GetUrl(), fmt.Sprintf("http://%s/v2", host); got != want {
+				t.Fatalf("url = %s, want %s", got, want)
+			}
+
+			tags := &TagList{Tags: []string{}}
+			status := registry.TagFetcherFunc(configuredRegistry.Registry.GetUrl(), configuredRegistry.TLS, AuthTypes.None, "", "team/app", tags)
+			if status != 200 || strings.Join(tags.Tags, ",") != "1.0.0,1.1.0" {
+				t.Errorf("got status %d and tags %v from the plain http registry", status, tags.Tags)
+			}
+		})
+	}
+}
+
+func TestRegistryDomainErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		message string
+	}{
+		{name: "insecure with https", options: "domain: localhost:5000\n    insecure: true\n    scheme: https", message: "is insecure but has scheme https"},
+		{name: "unknown scheme", options: "domain: localhost:5000\n    scheme: ftp", message: "Unknown scheme"},
+		{name: "domain with path", options: "domain: https://registry.lan/v2", message: "must not have a path"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := parseConfig(t, fmt.Sprintf("registries:\n  local:\n    %s\n", test.options))
+			if ErrorKindOf(err) != ErrorKinds.Parse || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("got error %v, want a parse error containing %q", err, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+type dockerConfigFile struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// Docker Hub credentials are stored under its legacy index url
+const dockerHubServerUrl = "https://index.docker.io/v1/"
+
+var errCredentialsNotFound = errors.New("credentials not found")
+
+// Auth files in the order they are searched, Podman before Docker
+func authFilePaths() []string {
+	paths := []string{}
+	if path := os.Getenv("REGISTRY_AUTH_FILE"); path != "" {
+		paths = append(paths, path)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "containers", "auth.json"))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "containers", "auth.json"))
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "config.json"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".docker", "config.json"))
+	}
+	return paths
+}
+
+// Reduces auth file keys like https://index.docker.io/v1/ to their host
+func normalizeAuthKey(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	switch key {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return key
+}
+
+func helperServerUrl(domain string) string {
+	if domain == "docker.io" {
+		return dockerHubServerUrl
+	}
+	return domain
+}
+
+func basicToken(username string, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
+}
+
+// Runs docker-credential-<helper> get for the domain
+func helperCredentialsFor(helper string, domain string) (AuthType, string, error) {
+	cmd := exec.Command(fmt.Sprintf("docker-credential-%s", helper), "get")
+	cmd.Stdin = strings.NewReader(helperServerUrl(domain))
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return AuthTypes.None, "", errCredentialsNotFound
+		}
+		return AuthTypes.None, "", fmt.Errorf("credential helper %s: %w", helper, err)
+	}
+
+	credentials := helperCredentials{}
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return AuthTypes.None, "", fmt.Errorf("credential helper %s: %w", helper, err)
+	}
+	// Identity tokens need an OAuth refresh flow that is not supported
+	if credentials.Username == "<token>" {
+		return AuthTypes.None, "", fmt.Errorf("credential helper %s returned an identity token, which is not supported", helper)
+	}
+	return AuthTypes.Basic, basicToken(credentials.Username, credentials.Secret), nil
+}
+
+func authFileCredentialsFor(configFile dockerConfigFile, domain string) (AuthType, string, error) {
+	for key, helper := range configFile.CredHelpers {
+		if normalizeAuthKey(key) == domain {
+			return helperCredentialsFor(helper, domain)
+		}
+	}
+	for key, auth := range configFile.Auths {
+		if normalizeAuthKey(key) != domain {
+			continue
+		}
+		if auth.Auth != "" {
+			return AuthTypes.Basic, auth.Auth, nil
+		}
+		if auth.Username != "" {
+			return AuthTypes.Basic, basicToken(auth.Username, auth.Password), nil
+		}
+		if auth.IdentityToken != "" {
+			return AuthTypes.None, "", fmt.Errorf("identity token for %s is not supported", domain)
+		}
+	}
+	if configFile.CredsStore != "" {
+		return helperCredentialsFor(configFile.CredsStore, domain)
+	}
+	return AuthTypes.None, "", errCredentialsNotFound
+}
+
+// Resolves credentials for a domain from the Docker and Podman auth files
+// and their credential helpers. Returns the auth file the credentials were
+// found in.
+func dockerConfigCredentials(domain string) (AuthType, string, string, error) {
+	for _, path := range authFilePaths() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return AuthTypes.None, "", path, err
+		}
+		configFile := dockerConfigFile{}
+		if err := json.Unmarshal(data, &configFile); err != nil {
+			return AuthTypes.None, "", path, fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		authType, token, err := authFileCredentialsFor(configFile, domain)
+		if errors.Is(err, errCredentialsNotFound) {
+			continue
+		}
+		return authType, token, path, err
+	}
+	return AuthTypes.None, "", "", errCredentialsNotFound
+}
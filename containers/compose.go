@@ -0,0 +1,186 @@
+package containers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	. "github.com/mlofjard/contrack/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Labels can be written either as a map or as a list of key=value
+type composeLabels map[string]string
+
+func (l *composeLabels) UnmarshalYAML(node *yaml.Node) error {
+	*l = composeLabels{}
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, item := range list {
+			key, value, _ := strings.Cut(item, "=")
+			(*l)[key] = value
+		}
+		return nil
+	}
+	var m map[string]string
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	for key, value := range m {
+		(*l)[key] = value
+	}
+	return nil
+}
+
+type composeService struct {
+	Image         string        `yaml:"image"`
+	ContainerName string        `yaml:"container_name"`
+	Labels        composeLabels `yaml:"labels"`
+}
+
+type composeFile struct {
+	Name     string                    `yaml:"name"`
+	Services map[string]composeService `yaml:"services"`
+}
+
+var invalidProjectChars = regexp.MustCompile("[^a-z0-9_-]")
+
+// Reads KEY=VALUE lines from a .env file, a missing file is not an error
+func readEnvFile(path string) (map[string]string, error) {
+	env := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return env, nil
+		}
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[strings.TrimSpace(key)] = value
+	}
+	return env, scanner.Err()
+}
+
+// Parses the compose files as one project, later files override earlier ones
+func parseComposeProject(paths []string) ([]Container, error) {
+	projectDir := filepath.Dir(paths[0])
+	dotEnv, err := readEnvFile(filepath.Join(projectDir, ".env"))
+	if err != nil {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+	// Variables from the environment take precedence over .env
+	lookup := func(name string) (string, bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			return v, true
+		}
+		v, ok := dotEnv[name]
+		return v, ok
+	}
+
+	absDir, _ := filepath.Abs(projectDir)
+	projectName := filepath.Base(absDir)
+	services := make(map[string]composeService)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file := composeFile{}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if file.Name != "" {
+			projectName = file.Name
+		}
+
+		for serviceName, service := range file.Services {
+			merged := services[serviceName]
+			if service.Image != "" {
+				merged.Image = service.Image
+			}
+			if service.ContainerName != "" {
+				merged.ContainerName = service.ContainerName
+			}
+			if merged.Labels == nil {
+				merged.Labels = composeLabels{}
+			}
+			for key, value := range service.Labels {
+				merged.Labels[key] = value
+			}
+			services[serviceName] = merged
+		}
+	}
+	if projectName, err = interpolate(projectName, lookup); err != nil {
+		return nil, err
+	}
+	projectName = invalidProjectChars.ReplaceAllString(strings.ToLower(projectName), "")
+
+	result := []Container{}
+	for _, serviceName := range slices.Sorted(maps.Keys(services)) {
+		service := services[serviceName]
+		// Services that are only built locally have nothing to track
+		if service.Image == "" {
+			continue
+		}
+
+		image, err := interpolate(service.Image, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", serviceName, err)
+		}
+		name := fmt.Sprintf("%s-%s-1", projectName, serviceName)
+		if service.ContainerName != "" {
+			if name, err = interpolate(service.ContainerName, lookup); err != nil {
+				return nil, fmt.Errorf("service %s: %w", serviceName, err)
+			}
+		}
+		labels := make(map[string]string, len(service.Labels))
+		for key, value := range service.Labels {
+			if labels[key], err = interpolate(value, lookup); err != nil {
+				return nil, fmt.Errorf("service %s: %w", serviceName, err)
+			}
+		}
+
+		result = append(result, Container{Name: name, Image: image, Labels: labels})
+	}
+	return result, nil
+}
+
+func ComposeDiscoveryFunc(config Config) ([]Container, error) {
+	if len(config.DiscoveryPaths) == 0 {
+		return nil, NewError(ErrorKinds.Parse, "Compose discovery needs at least one compose file path", nil)
+	}
+
+	result, err := parseComposeProject(config.DiscoveryPaths)
+	if err != nil {
+		return nil, fileDiscoveryError("Error reading compose files", err)
+	}
+	if config.Debug {
+		fmt.Println("Compose services found:", len(result))
+	}
+	return result, nil
+}
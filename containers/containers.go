@@ -2,48 +2,184 @@ package containers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"log"
+	"net/http"
 	"regexp"
 	"slices"
-	"sort"
 	"strings"
-	"text/tabwriter"
 
-	"github.com/Masterminds/semver"
+	"github.com/mlofjard/contrack/registry"
+	"github.com/mlofjard/contrack/tlsconfig"
 	. "github.com/mlofjard/contrack/types"
+	"github.com/mlofjard/contrack/versioning"
 
 	"github.com/distribution/reference"
 	apiContainer "github.com/docker/docker/api/types/container"
 	apiClient "github.com/docker/docker/client"
 )
 
-func DiscoveryFunc(config Config) []Container {
+// map from discovery source to discovery function
+var DiscoveryFuncs = map[string]ContainerDiscoveryFn{
+	"docker":     DiscoveryFunc,
+	"compose":    ComposeDiscoveryFunc,
+	"kubernetes": KubernetesDiscoveryFunc,
+	"dockerfile": DockerfileDiscoveryFunc,
+}
+
+// Wraps an error from file based discovery, missing files are not found and
+// anything else could not be parsed
+func fileDiscoveryError(message string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewError(ErrorKinds.NotFound, message, err)
+	}
+	return NewError(ErrorKinds.Parse, message, err)
+}
+
+// Creates a docker API client for the host endpoint. Only a malformed
+// endpoint is a parse error, anything else fails to connect to the host.
+func newDockerClient(host DockerHost) (*apiClient.Client, error) {
+	if _, err := apiClient.ParseHostURL(host.Endpoint); err != nil {
+		return nil, NewError(ErrorKinds.Parse, fmt.Sprintf("Invalid docker host %s", host.Endpoint), err)
+	}
+	if strings.HasPrefix(host.Endpoint, "ssh://") {
+		dialer, err := sshDialer(host.Endpoint)
+		if err != nil {
+			return nil, NewError(ErrorKinds.Parse, fmt.Sprintf("Invalid docker host %s", host.Endpoint), err)
+		}
+		// The host is only used in request urls, the dialer decides where they go
+		client, err := apiClient.NewClientWithOpts(apiClient.WithHost("http://docker.example.com"), apiClient.WithDialContext(dialer))
+		if err != nil {
+			return nil, NewError(ErrorKinds.Network, "Error creating docker client", err)
+		}
+		return client, nil
+	}
+
+	tlsConfig, err := tlsconfig.Client(host.TLS)
+	if err != nil {
+		return nil, NewError(ErrorKinds.Network, "Error loading docker host certificates", err)
+	}
+	opts := []apiClient.Opt{}
+	if tlsConfig != nil {
+		// Set before the host, which configures the transport of the client
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		opts = append(opts, apiClient.WithHTTPClient(client))
+	}
+	opts = append(opts, apiClient.WithHost(host.Endpoint))
+	client, err := apiClient.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, NewError(ErrorKinds.Network, "Error creating docker client", err)
+	}
+	return client, nil
+}
+
+// Discovers containers on all configured hosts. A failing host is reported
+// as a container with an error, discovery only fails when no host could be reached.
+func DiscoveryFunc(config Config) ([]Container, error) {
+	hosts := config.Hosts
+	if len(hosts) == 0 {
+		hosts = []DockerHost{{Endpoint: config.Host}}
+	}
+
+	result := []Container{}
+	errs := []error{}
+	for _, host := range hosts {
+		containers, err := discoverHost(config, host)
+		if err != nil {
+			if len(hosts) > 1 {
+				log.Printf("Skipping host %s: %v", host.Name, err)
+			}
+			errs = append(errs, err)
+			result = append(result, Container{Name: fmt.Sprintf("%s (host)", host.Name), Host: host.Name, Err: err})
+			continue
+		}
+		result = append(result, containers...)
+	}
+	if len(errs) == len(hosts) {
+		return nil, errors.Join(errs...)
+	}
+	return result, nil
+}
+
+func discoverHost(config Config, host DockerHost) ([]Container, error) {
 	// Setup docker API client
-	client, err := apiClient.NewClientWithOpts(apiClient.WithHost(config.Host))
+	client, err := newDockerClient(host)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer client.Close()
 
 	// Fetch list on containers
 	containers, err := client.ContainerList(context.Background(), apiContainer.ListOptions{All: config.IncludeAll})
 	if err != nil {
-		panic(err)
+		return nil, NewError(ErrorKinds.Network, "Error listing containers", err)
 	}
 
+	// Updates must be available for the platform of the host
+	platform := ""
+	info, err := client.Info(context.Background())
+	if err != nil {
+		if config.Debug {
+			fmt.Printf("Could not get host platform: %v\n", err)
+		}
+	} else {
+		platform = registry.NormalizePlatform(fmt.Sprintf("%s/%s", info.OSType, info.Architecture))
+	}
+
+	// Repo digests are needed to check floating tags, inspect each image once
+	imageDigests := make(map[string][]string)
 	result := make([]Container, len(containers))
 	for idx, ctr := range containers {
-		result[idx] = Container{Name: strings.TrimPrefix(ctr.Names[0], "/"), Image: ctr.Image, Labels: ctr.Labels}
+		digests, ok := imageDigests[ctr.ImageID]
+		if !ok {
+			inspect, _, err := client.ImageInspectWithRaw(context.Background(), ctr.ImageID)
+			if err != nil && config.Debug {
+				fmt.Printf("Could not inspect image %s: %v\n", ctr.ImageID, err)
+			}
+			digests = inspect.RepoDigests
+			imageDigests[ctr.ImageID] = digests
+		}
+		result[idx] = Container{Name: strings.TrimPrefix(ctr.Names[0], "/"), Image: ctr.Image, Labels: ctr.Labels, Digests: digests, Platform: platform, Host: host.Name}
+	}
+	return result, nil
+}
+
+// Returns the digest of the repo digest that belongs to the named repository
+func matchingDigest(named reference.Named, repoDigests []string) string {
+	for _, repoDigest := range repoDigests {
+		parsed, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		digested, ok := parsed.(reference.Digested)
+		if ok && parsed.Name() == named.Name() {
+			return digested.Digest().String()
+		}
 	}
-	return result
+	return ""
 }
 
-func createTrackedContainer(name string, image string, include string, transform string, repoWithRegistryMap DomainConfiguredRegistryMap) TrackedContainer {
-	parsed, _ := reference.ParseDockerRef(image)
-	domain := reference.Domain(parsed)
+func createTrackedContainer(name string, host string, image string, digests []string, labels ContainerLabels, repoWithRegistryMap DomainConfiguredRegistryMap) TrackedContainer {
+	parsed, _ := reference.ParseNormalizedNamed(image)
+	// Port qualified domains like localhost:5000 are kept whole, the port
+	// tells registries on the same host apart
+	domain := registry.NormalizeDomain(reference.Domain(parsed))
 	path := reference.Path(parsed)
-	tag := strings.Split(parsed.String(), ":")[1]
+
+	// Images pinned by digest carry it in the reference itself and have no
+	// tag, other images without a tag implicitly run latest
+	tag := "latest"
+	digest := matchingDigest(parsed, digests)
+	if digested, ok := parsed.(reference.Digested); ok {
+		digest = digested.Digest().String()
+		tag = ""
+	}
+	if tagged, ok := parsed.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+	compileLabels(&labels)
 	tracked := false
 	if _, foundInConfig := repoWithRegistryMap[domain]; foundInConfig {
 		tracked = true
@@ -51,245 +187,402 @@ func createTrackedContainer(name string, image string, include string, transform
 
 	return TrackedContainer{
 		Name:    name,
+		Host:    host,
 		Tracked: tracked,
-		Labels: ContainerLabels{
-			Include:   include,
-			Transform: transform,
-		},
+		Labels:  labels,
 		Image: ContainerImage{
 			Path:   path,
 			Tag:    tag,
 			Domain: domain,
+			Digest: digest,
 		},
 	}
 
 }
 
 func getTrackedContainer(container Container, repoWithRegistryMap DomainConfiguredRegistryMap) TrackedContainer {
-	includeLabel := ""
-	transformLabel := ""
+	labels := ContainerLabels{Platform: container.Platform}
 	if label, ok := container.Labels["wud.tag.include"]; ok {
-		includeLabel = label
+		labels.Include = label
 	}
 	if label, ok := container.Labels["wud.tag.transform"]; ok {
-		transformLabel = label
+		labels.Transform = label
 	}
 	if label, ok := container.Labels["contrack.include"]; ok {
-		includeLabel = label
+		labels.Include = label
 	}
 	if label, ok := container.Labels["contrack.transform"]; ok {
-		transformLabel = label
+		labels.Transform = label
+	}
+	if label, ok := container.Labels["contrack.strategy"]; ok {
+		labels.Strategy = label
+	}
+	if label, ok := container.Labels["contrack.policy"]; ok {
+		labels.Policy = label
+	}
+	if label, ok := container.Labels["contrack.constraint"]; ok {
+		labels.Constraint = label
+	}
+	if label, ok := container.Labels["contrack.platform"]; ok {
+		labels.Platform = registry.NormalizePlatform(label)
 	}
 
-	return createTrackedContainer(container.Name, container.Image, includeLabel, transformLabel, repoWithRegistryMap)
+	return createTrackedContainer(container.Name, container.Host, container.Image, container.Digests, labels, repoWithRegistryMap)
 }
 
 func getTrackedParentContainer(container Container, parentImage string, repoWithRegistryMap DomainConfiguredRegistryMap) TrackedContainer {
-	includeLabel := ""
-	transformLabel := ""
+	labels := ContainerLabels{Platform: container.Platform}
 	if label, ok := container.Labels["contrack.parent.include"]; ok {
-		includeLabel = label
+		labels.Include = label
 	}
 	if label, ok := container.Labels["contrack.parent.transform"]; ok {
-		transformLabel = label
+		labels.Transform = label
+	}
+	if label, ok := container.Labels["contrack.parent.strategy"]; ok {
+		labels.Strategy = label
+	}
+	if label, ok := container.Labels["contrack.parent.policy"]; ok {
+		labels.Policy = label
+	}
+	if label, ok := container.Labels["contrack.parent.constraint"]; ok {
+		labels.Constraint = label
+	}
+	if label, ok := container.Labels["contrack.parent.platform"]; ok {
+		labels.Platform = registry.NormalizePlatform(label)
 	}
 
 	parentName := fmt.Sprintf("%s (parent)", container.Name)
-	return createTrackedContainer(parentName, parentImage, includeLabel, transformLabel, repoWithRegistryMap)
+	return createTrackedContainer(parentName, container.Host, parentImage, nil, labels, repoWithRegistryMap)
 }
 
-func GetContainers(config Config, repoWithRegistryMap DomainConfiguredRegistryMap, containerFn ContainerDiscoveryFn) TrackedContainers {
-	containers := containerFn(config)
+func GetContainers(config Config, repoWithRegistryMap DomainConfiguredRegistryMap, containerFn ContainerDiscoveryFn) (TrackedContainers, error) {
+	containers, err := containerFn(config)
+	if err != nil {
+		return nil, err
+	}
 
-	// Sort containers by name
+	// Sort containers by name, then host
 	slices.SortFunc(containers, func(a Container, b Container) int {
-		return strings.Compare(a.Name, b.Name)
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Host, b.Host)
 	})
 
 	// trackedContainers := make(TrackedContainers, len(containers))
 	trackedContainers := TrackedContainers{}
 	for _, ctr := range containers {
+		if ctr.Err != nil {
+			trackedContainers = append(trackedContainers, TrackedContainer{Name: ctr.Name, Host: ctr.Host, Err: ctr.Err})
+			continue
+		}
+		// File based discovery can yield unresolvable images, e.g. from an ARG without default
+		if _, err := reference.ParseDockerRef(ctr.Image); err != nil {
+			log.Printf("Skipping %s, invalid image %q: %v", ctr.Name, ctr.Image, err)
+			continue
+		}
 		trackedContainer := getTrackedContainer(ctr, repoWithRegistryMap)
 		trackedContainers = append(trackedContainers, trackedContainer)
 
 		if label, ok := ctr.Labels["contrack.parent.image"]; ok {
+			if _, err := reference.ParseDockerRef(label); err != nil {
+				log.Printf("Skipping parent of %s, invalid image %q: %v", ctr.Name, label, err)
+				continue
+			}
 			parentContainer := getTrackedParentContainer(ctr, label, repoWithRegistryMap)
 			trackedContainers = append(trackedContainers, parentContainer)
 		}
 	}
 
-	return trackedContainers
+	return trackedContainers, nil
+}
+
+// Compiles the include label and the regex of the transform label,
+// "<regex> => <replacement>", once for every tag they are applied to
+func compileLabels(labels *ContainerLabels) {
+	includeRegex, err := regexp.Compile(labels.Include)
+	if err != nil {
+		labels.Err = fmt.Errorf("Invalid include label: %w", err)
+		return
+	}
+	labels.IncludeRegex = includeRegex
+
+	if labels.Transform == "" {
+		return
+	}
+	replaceSplit := strings.Split(labels.Transform, "=>")
+	transformRegex, err := regexp.Compile(strings.TrimSpace(replaceSplit[0]))
+	if err != nil {
+		labels.IncludeRegex = nil
+		labels.Err = fmt.Errorf("Invalid transform label: %w", err)
+		return
+	}
+	labels.TransformRegex = transformRegex
+	if len(replaceSplit) > 1 {
+		labels.Replacement = strings.TrimSpace(replaceSplit[1])
+	}
+}
+
+// Returns a function applying the compiled transform label to tags
+func tagTransformer(labels ContainerLabels) func(string) string {
+	if labels.TransformRegex == nil {
+		return func(tag string) string { return tag }
+	}
+	return func(tag string) string {
+		return labels.TransformRegex.ReplaceAllString(tag, labels.Replacement)
+	}
+}
+
+// Shortens a digest like sha256:<hex> to its first 12 hex characters
+func shortDigest(digest string) string {
+	algorithm, hex, found := strings.Cut(digest, ":")
+	if !found || len(hex) <= 12 {
+		return digest
+	}
+	return fmt.Sprintf("%s:%s", algorithm, hex[:12])
+}
+
+// Floating tags like latest can only be checked by comparing digests
+func isFloatingTag(ctr TrackedContainer) bool {
+	if ctr.Image.Tag == "" || ctr.Labels.Err != nil {
+		return false
+	}
+	strategy, err := versioning.NewStrategy(ctr.Labels.Strategy)
+	if err != nil {
+		return false
+	}
+	_, err = strategy.Parse(tagTransformer(ctr.Labels)(ctr.Image.Tag))
+	return err != nil
 }
 
 func GroupContainers(config Config, domainGroupedRepoMap DomainGroupedRepoMap, domainConfiguredRegistryMap DomainConfiguredRegistryMap, trackedContainers TrackedContainers) int {
 	uniqueImageCount := 0
 
 	for _, ctr := range trackedContainers {
+		// Reported as invalid_label, without asking the registry
+		if ctr.Labels.Err != nil {
+			continue
+		}
 		domain := ctr.Image.Domain
 		path := ctr.Image.Path
 		if _, foundInConfig := domainConfiguredRegistryMap[domain]; foundInConfig {
 			// If config section found
-			if domainGroup, foundInMap := domainGroupedRepoMap[domain]; !foundInMap {
+			domainGroup, foundInMap := domainGroupedRepoMap[domain]
+			if !foundInMap {
 				// If map key is missing, set map key and add image
-				domainGroupedRepoMap[domain] = GroupedRepository{
-					Domain: domain,
-					Paths:  []string{path},
+				domainGroup = GroupedRepository{
+					Domain:     domain,
+					Paths:      []string{path},
+					DigestTags: make(map[string][]string),
 				}
 				uniqueImageCount++
 			} else {
 				// If map key exists, just append image (if unique)
 				if !slices.Contains(domainGroup.Paths, path) {
 					domainGroup.Paths = append(domainGroup.Paths, path)
-					domainGroupedRepoMap[domain] = domainGroup
 					uniqueImageCount++
 				}
 			}
+
+			// Floating tags of images with a known digest get their remote digest fetched
+			if ctr.Image.Digest != "" && isFloatingTag(ctr) && !slices.Contains(domainGroup.DigestTags[path], ctr.Image.Tag) {
+				domainGroup.DigestTags[path] = append(domainGroup.DigestTags[path], ctr.Image.Tag)
+			}
+			domainGroupedRepoMap[domain] = domainGroup
 		}
 	}
 	return uniqueImageCount
 }
 
-func mapOutput(columns []string, outputMap map[string]string) []any {
-	var output = make([]any, len(columns))
-	for idx, column := range columns {
-		output[idx] = outputMap[column]
+func setError(result *ContainerResult, code string, message string, registryStatus int) {
+	result.Status = "ERR"
+	result.Detail = message
+	result.Error = &ResultError{Code: code, Message: message, RegistryStatus: registryStatus}
+}
+
+// Error codes for failed tag fetches, by error kind
+var registryErrorCodes = map[ErrorKind]string{
+	ErrorKinds.Auth:        "registry_auth",
+	ErrorKinds.Network:     "registry_network",
+	ErrorKinds.NotFound:    "registry_not_found",
+	ErrorKinds.RateLimited: "registry_rate_limited",
+	ErrorKinds.Parse:       "registry_error",
+}
+
+func registryErrorCode(imageTags ImageTags) string {
+	if code, ok := registryErrorCodes[ErrorKindOf(imageTags.Err)]; ok {
+		return code
 	}
-	return output
+	if imageTags.Status >= 500 {
+		return "registry_server"
+	}
+	return "registry_error"
 }
 
-func ProcessTrackedContainers(config Config, imageTagMap ImageTagMap, trackedContainers TrackedContainers) {
-	semverMin, _ := semver.NewVersion("0.0.0-0")
+// Update candidate, a tag newer than the current one
+type candidate struct {
+	tag     string
+	version versioning.Version
+}
+
+func ProcessTrackedContainers(config Config, imageTagMap ImageTagMap, trackedContainers TrackedContainers, platformCheckFn PlatformCheckFn) []ContainerResult {
 	if config.Debug {
 		fmt.Println("Number of containers tracked:", len(trackedContainers))
 		fmt.Println("Imagetagmap", imageTagMap)
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	results := make([]ContainerResult, len(trackedContainers))
+	// Iterate over watched containers
+	for idx, ctr := range trackedContainers {
+		if ctr.Err != nil {
+			result := &results[idx]
+			result.Container = ctr.Name
+			result.Host = ctr.Host
+			setError(result, "host_unreachable", ctr.Err.Error(), 0)
+			continue
+		}
 
-	if len(trackedContainers) > 0 {
-		tableHeader := fmt.Sprintf("%s", strings.Join(config.Columns, "\t"))
-		formatSpecArr := make([]string, len(config.Columns))
-		for idx := range config.Columns {
-			formatSpecArr[idx] = "%s"
+		image := ctr.Image
+		repository := fmt.Sprintf("%s/%s", image.Domain, image.Path)
+		imageStr := fmt.Sprintf("%s:%s", repository, image.Tag)
+		if image.Tag == "" {
+			imageStr = fmt.Sprintf("%s@%s", repository, image.Digest)
+		}
+		if config.Debug {
+			fmt.Println("**** Name:", ctr.Name)
+			fmt.Println("**** Image:", image.Path)
+			fmt.Println("**** Include:", ctr.Labels.Include)
+			fmt.Println("**** Transform:", ctr.Labels.Transform)
+			fmt.Println("**** Strategy:", ctr.Labels.Strategy)
+			fmt.Println("**** Policy:", ctr.Labels.Policy)
+			fmt.Println("**** Constraint:", ctr.Labels.Constraint)
+			fmt.Println("**** Platform:", ctr.Labels.Platform)
 		}
-		formatSpec := fmt.Sprintf("%s\n", strings.Join(formatSpecArr, "\t"))
-		fmt.Fprintln(w, strings.ToUpper(tableHeader))
-
-		output := make([]map[string]string, len(trackedContainers))
-		// Iterate over watched containers
-		for idx, ctr := range trackedContainers {
-			image := ctr.Image
-			repository := fmt.Sprintf("%s/%s", image.Domain, image.Path)
-			imageStr := fmt.Sprintf("%s:%s", repository, image.Tag)
-			if config.Debug {
-				fmt.Println("**** Name:", ctr.Name)
-				fmt.Println("**** Image:", image.Path)
-				fmt.Println("**** Include:", ctr.Labels.Include)
-				fmt.Println("**** Transform:", ctr.Labels.Transform)
-			}
 
-			output[idx] = make(map[string]string)
-			output[idx]["status"] = "OK"
-			output[idx]["detail"] = ""
-			output[idx]["container"] = ctr.Name
-			output[idx]["image"] = imageStr
-			output[idx]["repository"] = repository
-			output[idx]["domain"] = image.Domain
-			output[idx]["path"] = image.Path
-			output[idx]["tag"] = image.Tag
-
-			if imageTags, ok := imageTagMap[repository]; ok {
-				// If imageTags exists
-
-				if imageTags.Status != 200 {
-					output[idx]["status"] = "ERR"
-					switch imageTags.Status {
-					case 401:
-						output[idx]["detail"] = "Registry authentication error"
-					case 500:
-						output[idx]["detail"] = "Registry server error"
-					default:
-						output[idx]["detail"] = fmt.Sprintf("Registry error %d", imageTags.Status)
-					}
-				} else {
-					includeRegex, _ := regexp.Compile(ctr.Labels.Include)
-					replaceSplit := strings.Split(ctr.Labels.Transform, "=>")
-					transformedTag := image.Tag
-
-					transformRegex, _ := regexp.Compile(strings.TrimSpace(replaceSplit[0]))
-					if ctr.Labels.Transform != "" {
-						transformedTag = transformRegex.ReplaceAllString(image.Tag, strings.TrimSpace(replaceSplit[1]))
-					}
+		result := &results[idx]
+		result.Status = "OK"
+		result.Container = ctr.Name
+		result.Host = ctr.Host
+		result.Image = imageStr
+		result.Repository = repository
+		result.Domain = image.Domain
+		result.Path = image.Path
+		result.Tag = image.Tag
+		result.Digest = image.Digest
+		result.Platform = ctr.Labels.Platform
+
+		if ctr.Labels.Err != nil {
+			setError(result, "invalid_label", ctr.Labels.Err.Error(), 0)
+			continue
+		}
 
-					if config.Debug {
-						fmt.Println("**** > Transformed tag:", transformedTag)
-					}
+		if imageTags, ok := imageTagMap[repository]; ok {
+			// If imageTags exists
+
+			if imageTags.Err != nil {
+				setError(result, registryErrorCode(imageTags), imageTags.Err.Error(), imageTags.Status)
+			} else if image.Digest != "" && isFloatingTag(ctr) {
+				// Floating tags are up to date when the remote digest is unchanged
+				remoteDigest := imageTags.Digests[image.Tag]
+				result.RemoteDigest = remoteDigest
+				if config.Debug {
+					fmt.Println("**** > Local digest:", image.Digest)
+					fmt.Println("**** > Remote digest:", remoteDigest)
+				}
+				if remoteDigest == "" {
+					setError(result, "digest_unavailable", "Remote digest could not be fetched", 0)
+				} else if remoteDigest != image.Digest {
+					// The tag is unchanged, the short remote digest tells updates apart
+					result.Update = fmt.Sprintf("%s@%s", image.Tag, shortDigest(remoteDigest))
+					result.Detail = "Digest changed"
+				}
+			} else {
+				transform := tagTransformer(ctr.Labels)
+				transformedTag := transform(image.Tag)
 
-					localSemver, err := semver.NewVersion(transformedTag)
-					if err != nil {
-						localSemver = semverMin
-						output[idx]["status"] = "ERR"
-						output[idx]["detail"] = "Current tag could not be read as SemVer"
-					}
-					// fmt.Fprintf(w, "      Local tag: %s (%s)\n", image.Tag, localSemver)
+				if config.Debug {
+					fmt.Println("**** > Transformed tag:", transformedTag)
+				}
 
-					filteredTags := slices.DeleteFunc(slices.Clone(imageTags.Tags), func(t string) bool { return !includeRegex.MatchString(t) })
+				strategy, err := versioning.NewStrategy(ctr.Labels.Strategy)
+				if err != nil {
+					setError(result, "invalid_strategy", fmt.Sprintf("Invalid strategy label: %v", err), 0)
+					continue
+				}
 
-					if config.Debug {
-						fmt.Printf("**** > Filtered tags: %d\n", len(filteredTags))
-					}
+				policy, err := versioning.NewPolicy(ctr.Labels.Policy, ctr.Labels.Constraint, strategy)
+				if err != nil {
+					setError(result, "invalid_policy", fmt.Sprintf("Invalid policy label: %v", err), 0)
+					continue
+				}
 
-					transformedTags := make([]string, len(filteredTags))
-					semverTags := make([]*semver.Version, len(filteredTags))
-					semverFilteredMap := make(map[string]string, len(filteredTags))
-					for i, ft := range filteredTags {
-						tt := ft
-						if ctr.Labels.Transform != "" {
-							tt = transformRegex.ReplaceAllString(ft, strings.TrimSpace(replaceSplit[1]))
-						}
-						v, err := semver.NewVersion(tt)
-						if err != nil {
-							//fmt.Fprintf(w, "Error parsing version: %s", err)
-							v = semverMin
-						}
+				// An unreadable local tag makes every matching tag an update
+				localVersion, err := strategy.Parse(transformedTag)
+				if err != nil {
+					localVersion = nil
+					setError(result, "tag_unparseable", fmt.Sprintf("Current tag could not be read as %s", strategy.Name()), 0)
+				}
 
-						semverTags[i] = v
-						transformedTags[i] = tt
-						semverFilteredMap[v.String()] = filteredTags[i] // this works because filteredTags is same length as transformedTags
-					}
+				filteredTags := slices.DeleteFunc(slices.Clone(imageTags.Tags), func(t string) bool { return !ctr.Labels.IncludeRegex.MatchString(t) })
 
-					if config.Debug {
-						fmt.Printf("**** > Transformed tags: %d\n", len(transformedTags))
-					}
+				if config.Debug {
+					fmt.Printf("**** > Filtered tags: %d\n", len(filteredTags))
+				}
 
-					sort.Sort(semver.Collection(semverTags))
-					latestSemver := semverMin
-					if len(semverTags) > 0 {
-						latestSemver = semverTags[len(semverTags)-1]
-					} else {
-						output[idx]["status"] = "ERR"
-						output[idx]["detail"] = "No matching tags"
+				candidates := []candidate{}
+				parsedCount := 0
+				for _, ft := range filteredTags {
+					v, err := strategy.Parse(transform(ft))
+					if err != nil {
+						continue
 					}
-
-					c, _ := semver.NewConstraint(fmt.Sprintf("> %s", localSemver))
-					newVersion := c.Check(latestSemver)
-					if newVersion {
-						output[idx]["update"] = semverFilteredMap[latestSemver.String()]
+					parsedCount++
+					if versioning.IsUpdate(localVersion, v) {
+						candidates = append(candidates, candidate{tag: ft, version: v})
 					}
 				}
-			} else {
-				output[idx]["status"] = "ERR"
-				output[idx]["detail"] = "Config missing"
-				if ctr.Tracked {
-					output[idx]["detail"] = "No tags found"
+				// Newest first, later tags win ties like the registry listing order
+				slices.Reverse(candidates)
+				slices.SortStableFunc(candidates, func(a candidate, b candidate) int { return b.version.Compare(a.version) })
+
+				// Newest update allowed by the policy, and newest update overall, that
+				// are available for the platform
+				newest := func(allowed func(candidate) bool) string {
+					for _, c := range candidates {
+						if !allowed(c) {
+							continue
+						}
+						if ctr.Labels.Platform != "" && !platformCheckFn(image.Domain, image.Path, c.tag, ctr.Labels.Platform) {
+							if config.Debug {
+								fmt.Printf("**** > Skipping %s, not available for %s\n", c.tag, ctr.Labels.Platform)
+							}
+							continue
+						}
+						return c.tag
+					}
+					return ""
 				}
-			}
+				allowedTag := newest(func(c candidate) bool { return policy.Allows(localVersion, c.version) })
+				latestTag := newest(func(c candidate) bool { return true })
 
-			fmt.Fprintf(w, formatSpec, mapOutput(config.Columns, output[idx])...)
+				if config.Debug {
+					fmt.Printf("**** > %s tags: %d\n", strategy.Name(), parsedCount)
+				}
+
+				if parsedCount == 0 {
+					setError(result, "no_matching_tags", "No matching tags", 0)
+				}
+				result.Update = allowedTag
+				result.Latest = latestTag
+			}
+		} else {
+			if ctr.Tracked {
+				setError(result, "no_tags_found", "No tags found", 0)
+			} else {
+				setError(result, "config_missing", "Config missing", 0)
+			}
 		}
-	} else {
-		fmt.Println("No containers found")
 	}
 
-	w.Flush()
+	return results
 }
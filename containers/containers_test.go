@@ -0,0 +1,85 @@
+package containers
+
+import (
+	"testing"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+func TestCreateTrackedContainerPortDomains(t *testing.T) {
+	domainConfiguredRegistryMap := DomainConfiguredRegistryMap{
+		"localhost:5000":    {Domain: "localhost:5000"},
+		"registry.lan:5000": {Domain: "registry.lan:5000"},
+	}
+
+	tests := []struct {
+		image   string
+		domain  string
+		path    string
+		tag     string
+		tracked bool
+	}{
+		{image: "localhost:5000/app:1.0", domain: "localhost:5000", path: "app", tag: "1.0", tracked: true},
+		{image: "Registry.LAN:5000/app", domain: "registry.lan:5000", path: "app", tag: "latest", tracked: true},
+		{image: "registry.lan:5000/team/app:2.1", domain: "registry.lan:5000", path: "team/app", tag: "2.1", tracked: true},
+		// The port tells registries on the same host apart
+		{image: "localhost:5001/app:1.0", domain: "localhost:5001", path: "app", tag: "1.0", tracked: false},
+		{image: "registry.lan/app:1.0", domain: "registry.lan", path: "app", tag: "1.0", tracked: false},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			ctr := createTrackedContainer("app", "", test.image, nil, ContainerLabels{}, domainConfiguredRegistryMap)
+			if ctr.Image.Domain != test.domain || ctr.Image.Path != test.path || ctr.Image.Tag != test.tag {
+				t.Errorf("got %s/%s:%s, want %s/%s:%s", ctr.Image.Domain, ctr.Image.Path, ctr.Image.Tag, test.domain, test.path, test.tag)
+			}
+			if ctr.Tracked != test.tracked {
+				t.Errorf("tracked = %v, want %v", ctr.Tracked, test.tracked)
+			}
+		})
+	}
+}
+
+func TestInvalidRegexLabels(t *testing.T) {
+	domainConfiguredRegistryMap := DomainConfiguredRegistryMap{"docker.io": {Domain: "docker.io"}}
+	containers := []Container{
+		{Name: "include", Image: "nginx:latest", Digests: []string{"docker.io/library/nginx@sha256:1234"}, Labels: map[string]string{"contrack.include": "["}},
+		{Name: "transform", Image: "redis:7.2", Labels: map[string]string{"contrack.transform": "([ => $1"}},
+		{Name: "parent", Image: "redis:7.2", Labels: map[string]string{"contrack.parent.image": "alpine:3.20", "contrack.parent.include": "(?<"}},
+		{Name: "valid", Image: "postgres:16.1", Labels: map[string]string{"contrack.include": `^\d+\.\d+$`, "contrack.transform": "^v => "}},
+	}
+	trackedContainers := TrackedContainers{}
+	for _, ctr := range containers {
+		trackedContainers = append(trackedContainers, getTrackedContainer(ctr, domainConfiguredRegistryMap))
+		if parentImage, ok := ctr.Labels["contrack.parent.image"]; ok {
+			trackedContainers = append(trackedContainers, getTrackedParentContainer(ctr, parentImage, domainConfiguredRegistryMap))
+		}
+	}
+
+	// Containers with invalid labels are left out, redis is still needed by the container with the parent label
+	domainGroupedRepoMap := make(DomainGroupedRepoMap)
+	if count := GroupContainers(Config{}, domainGroupedRepoMap, domainConfiguredRegistryMap, trackedContainers); count != 2 {
+		t.Errorf("grouped %d images, want 2", count)
+	}
+
+	imageTagMap := ImageTagMap{
+		"docker.io/library/nginx":    {Status: 200, Tags: []string{"latest"}},
+		"docker.io/library/redis":    {Status: 200, Tags: []string{"7.2", "7.4"}},
+		"docker.io/library/alpine":   {Status: 200, Tags: []string{"3.20", "3.21"}},
+		"docker.io/library/postgres": {Status: 200, Tags: []string{"16.1", "16.2", "v17.0", "latest"}},
+	}
+	results := ProcessTrackedContainers(Config{}, imageTagMap, trackedContainers, nil)
+
+	want := map[string]string{"include": "invalid_label", "transform": "invalid_label", "parent (parent)": "invalid_label", "valid": ""}
+	for _, result := range results {
+		code := ""
+		if result.Error != nil {
+			code = result.Error.Code
+		}
+		if code != want[result.Container] {
+			t.Errorf("%s: got error code %q, want %q", result.Container, code, want[result.Container])
+		}
+	}
+	if update := results[len(results)-1].Update; update != "16.2" {
+		t.Errorf("valid: got update %q, want 16.2", update)
+	}
+}
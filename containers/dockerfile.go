@@ -0,0 +1,168 @@
+package containers
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+const directivePrefix = "contrack:"
+
+type dockerfileStage struct {
+	Index  int
+	Name   string
+	Image  string
+	Labels map[string]string
+}
+
+// Joins continuation lines into logical instructions. Comment lines are
+// returned as their own instructions so directives can be picked up.
+func dockerfileInstructions(data string) []string {
+	escape := "\\"
+	instructions := []string{}
+	current := ""
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// The escape parser directive must be first in the file
+		if first && strings.HasPrefix(strings.ToLower(strings.ReplaceAll(line, " ", "")), "#escape=") {
+			escape = strings.TrimSpace(line[strings.Index(line, "=")+1:])
+		}
+		first = false
+
+		if strings.HasPrefix(line, "#") {
+			if current == "" {
+				instructions = append(instructions, line)
+			}
+			continue
+		}
+		if strings.HasSuffix(line, escape) {
+			current += strings.TrimSuffix(line, escape) + " "
+			continue
+		}
+		current += line
+		if strings.TrimSpace(current) != "" {
+			instructions = append(instructions, strings.TrimSpace(current))
+		}
+		current = ""
+	}
+	if strings.TrimSpace(current) != "" {
+		instructions = append(instructions, strings.TrimSpace(current))
+	}
+	return instructions
+}
+
+func parseDockerfile(data string) ([]dockerfileStage, error) {
+	globalArgs := make(map[string]string)
+	lookup := func(name string) (string, bool) {
+		v, ok := globalArgs[name]
+		return v, ok
+	}
+
+	stages := []dockerfileStage{}
+	stageNames := []string{}
+	labels := make(map[string]string)
+	for _, instruction := range dockerfileInstructions(data) {
+		// Directives apply to the next FROM instruction
+		if strings.HasPrefix(instruction, "#") {
+			comment := strings.TrimSpace(strings.TrimPrefix(instruction, "#"))
+			if directive, found := strings.CutPrefix(comment, directivePrefix); found {
+				key, value, _ := strings.Cut(directive, "=")
+				labels["contrack."+strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+			continue
+		}
+
+		fields := strings.Fields(instruction)
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			// Only ARGs before the first FROM can be used in FROM lines
+			if len(stageNames) > 0 {
+				continue
+			}
+			for _, arg := range fields[1:] {
+				name, value, _ := strings.Cut(arg, "=")
+				globalArgs[name] = strings.Trim(value, "\"'")
+			}
+		case "FROM":
+			args := slices.DeleteFunc(fields[1:], func(f string) bool { return strings.HasPrefix(f, "--") })
+			if len(args) == 0 {
+				return nil, fmt.Errorf("FROM without image: %s", instruction)
+			}
+			image, err := interpolate(args[0], lookup)
+			if err != nil {
+				return nil, err
+			}
+			name := ""
+			if len(args) >= 3 && strings.EqualFold(args[1], "as") {
+				name = args[2]
+			}
+
+			// Earlier stages and scratch are not images that can be tracked
+			isStage := slices.ContainsFunc(stageNames, func(s string) bool { return strings.EqualFold(s, image) })
+			if !isStage && image != "scratch" && image != "" {
+				stages = append(stages, dockerfileStage{Index: len(stageNames), Name: name, Image: image, Labels: labels})
+			}
+			stageNames = append(stageNames, name)
+			labels = make(map[string]string)
+		}
+	}
+	return stages, nil
+}
+
+func isDockerfile(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	return base == "dockerfile" || base == "containerfile" ||
+		strings.HasPrefix(base, "dockerfile.") || strings.HasSuffix(base, ".dockerfile") ||
+		strings.HasPrefix(base, "containerfile.")
+}
+
+func DockerfileDiscoveryFunc(config Config) ([]Container, error) {
+	if len(config.DiscoveryPaths) == 0 {
+		return nil, NewError(ErrorKinds.Parse, "Dockerfile discovery needs at least one Dockerfile or directory path", nil)
+	}
+
+	result := []Container{}
+	for _, root := range config.DiscoveryPaths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Explicitly given files are always read, directories only yield Dockerfiles
+			if entry.IsDir() || (path != root && !isDockerfile(path)) {
+				return nil
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			stages, err := parseDockerfile(string(data))
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if config.Debug {
+				fmt.Printf("Dockerfile %s, Base images: %d\n", path, len(stages))
+			}
+			for _, stage := range stages {
+				name := fmt.Sprintf("%s:%d", path, stage.Index)
+				if stage.Name != "" {
+					name = fmt.Sprintf("%s:%s", path, stage.Name)
+				}
+				result = append(result, Container{Name: name, Image: stage.Image, Labels: stage.Labels})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fileDiscoveryError("Error reading Dockerfiles", err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,127 @@
+package containers
+
+import (
+	"fmt"
+	"strings"
+)
+
+type lookupFn = func(string) (string, bool)
+
+func isNameChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// Replaces $VAR, ${VAR} and the ${VAR:-default}, ${VAR-default},
+// ${VAR:+alt}, ${VAR+alt}, ${VAR:?err} and ${VAR?err} forms using lookup.
+// $$ is an escaped $.
+func interpolate(value string, lookup lookupFn) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '$' || i+1 == len(value) {
+			sb.WriteByte(c)
+			continue
+		}
+
+		next := value[i+1]
+		switch {
+		case next == '$':
+			sb.WriteByte('$')
+			i++
+		case next == '{':
+			end := matchingBrace(value, i+1)
+			if end == -1 {
+				return "", fmt.Errorf("unclosed variable expression in %q", value)
+			}
+			expanded, err := expandBraced(value[i+2:end], lookup)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(expanded)
+			i = end
+		case isNameChar(next, true):
+			end := i + 1
+			for end < len(value) && isNameChar(value[end], false) {
+				end++
+			}
+			v, _ := lookup(value[i+1 : end])
+			sb.WriteString(v)
+			i = end - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+// Finds the index of the brace closing the one at start, allowing nesting in defaults
+func matchingBrace(value string, start int) int {
+	depth := 0
+	for i := start; i < len(value); i++ {
+		switch value[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func expandBraced(expr string, lookup lookupFn) (string, error) {
+	nameEnd := 0
+	for nameEnd < len(expr) && isNameChar(expr[nameEnd], nameEnd == 0) {
+		nameEnd++
+	}
+	name := expr[:nameEnd]
+	if name == "" {
+		return "", fmt.Errorf("invalid variable expression ${%s}", expr)
+	}
+	v, set := lookup(name)
+	if nameEnd == len(expr) {
+		return v, nil
+	}
+
+	op := expr[nameEnd:]
+	colon := strings.HasPrefix(op, ":")
+	op = strings.TrimPrefix(op, ":")
+	if op == "" {
+		return "", fmt.Errorf("invalid variable expression ${%s}", expr)
+	}
+	// With a colon, an empty value counts as unset
+	present := set && (!colon || v != "")
+	// The word is only expanded when it is used, so ${A:-${B:?err}} works while A is set
+	word := op[1:]
+
+	switch op[0] {
+	case '-':
+		if present {
+			return v, nil
+		}
+		return interpolate(word, lookup)
+	case '+':
+		if present {
+			return interpolate(word, lookup)
+		}
+		return "", nil
+	case '?':
+		if present {
+			return v, nil
+		}
+		message, err := interpolate(word, lookup)
+		if err != nil {
+			return "", err
+		}
+		if message == "" {
+			message = "required variable is missing a value"
+		}
+		return "", fmt.Errorf("%s: %s", name, message)
+	}
+	return "", fmt.Errorf("invalid variable expression ${%s}", expr)
+}
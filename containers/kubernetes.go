@@ -0,0 +1,221 @@
+package containers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "github.com/mlofjard/contrack/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+type k8sContainer struct {
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+}
+
+type k8sPodSpec struct {
+	Containers     []k8sContainer `yaml:"containers"`
+	InitContainers []k8sContainer `yaml:"initContainers"`
+}
+
+type k8sMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type k8sPodTemplate struct {
+	Metadata k8sMetadata `yaml:"metadata"`
+	Spec     k8sPodSpec  `yaml:"spec"`
+}
+
+type k8sObject struct {
+	Kind     string      `yaml:"kind"`
+	Metadata k8sMetadata `yaml:"metadata"`
+	Spec     struct {
+		k8sPodSpec  `yaml:",inline"`
+		Template    k8sPodTemplate `yaml:"template"`
+		JobTemplate struct {
+			Spec struct {
+				Template k8sPodTemplate `yaml:"template"`
+			} `yaml:"spec"`
+		} `yaml:"jobTemplate"`
+	} `yaml:"spec"`
+	Items []k8sObject `yaml:"items"`
+}
+
+// Returns the pod template annotations and spec for the supported workload kinds
+func (o k8sObject) podSpec() (map[string]string, k8sPodSpec, bool) {
+	annotations := maps.Clone(o.Metadata.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	var template k8sPodTemplate
+	switch o.Kind {
+	case "Pod":
+		return annotations, o.Spec.k8sPodSpec, true
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		template = o.Spec.Template
+	case "CronJob":
+		template = o.Spec.JobTemplate.Spec.Template
+	default:
+		return nil, k8sPodSpec{}, false
+	}
+
+	// Pod template annotations override the workload ones
+	maps.Copy(annotations, template.Metadata.Annotations)
+	return annotations, template.Spec, true
+}
+
+// Options that can be set by annotation, as contrack.io/<option> for all
+// containers or contrack.io/<option>.<container> for a single container
+var annotationOptions = []string{
+	"include", "transform", "strategy", "policy", "constraint", "platform",
+	"parent.image", "parent.include", "parent.transform", "parent.strategy", "parent.policy", "parent.constraint", "parent.platform",
+}
+
+// Returns the labels of a container from annotations. Label style keys like
+// contrack.include apply to all containers, container specific keys win.
+func containerLabels(annotations map[string]string, containerName string) map[string]string {
+	labels := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if !strings.Contains(key, "/") {
+			labels[key] = value
+		}
+	}
+	for key, value := range annotations {
+		if option, found := strings.CutPrefix(key, "contrack.io/"); found && slices.Contains(annotationOptions, option) {
+			labels["contrack."+option] = value
+		}
+	}
+	for key, value := range annotations {
+		option, found := strings.CutPrefix(key, "contrack.io/")
+		if !found || slices.Contains(annotationOptions, option) {
+			continue
+		}
+		// Container names can't contain dots, so the container is after the last one
+		separator := strings.LastIndex(option, ".")
+		if separator == -1 || option[separator+1:] != containerName {
+			continue
+		}
+		if option = option[:separator]; slices.Contains(annotationOptions, option) {
+			labels["contrack."+option] = value
+		}
+	}
+	return labels
+}
+
+func k8sObjectContainers(object k8sObject) []Container {
+	result := []Container{}
+	if object.Kind == "List" {
+		for _, item := range object.Items {
+			result = append(result, k8sObjectContainers(item)...)
+		}
+		return result
+	}
+
+	annotations, spec, ok := object.podSpec()
+	if !ok {
+		return result
+	}
+
+	prefix := object.Metadata.Name
+	if object.Metadata.Namespace != "" {
+		prefix = fmt.Sprintf("%s/%s", object.Metadata.Namespace, prefix)
+	}
+	for _, ctr := range spec.InitContainers {
+		name := fmt.Sprintf("%s/%s (init)", prefix, ctr.Name)
+		result = append(result, Container{Name: name, Image: ctr.Image, Labels: containerLabels(annotations, ctr.Name)})
+	}
+	for _, ctr := range spec.Containers {
+		name := fmt.Sprintf("%s/%s", prefix, ctr.Name)
+		result = append(result, Container{Name: name, Image: ctr.Image, Labels: containerLabels(annotations, ctr.Name)})
+	}
+	return result
+}
+
+// Reads all YAML documents in a manifest file, like the output of `helm template`.
+// Documents that are not shaped like workloads, such as custom resources, are skipped.
+func parseManifestFile(path string) ([]Container, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := []Container{}
+	decoder := yaml.NewDecoder(file)
+	for document := 1; ; document++ {
+		node := yaml.Node{}
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Invalid YAML ends the stream, the documents before it are still used
+			if len(result) == 0 {
+				return nil, err
+			}
+			log.Printf("Skipping rest of %s from document %d: %v", path, document, err)
+			break
+		}
+
+		object := k8sObject{}
+		if err := node.Decode(&object); err != nil {
+			log.Printf("Skipping document %d in %s: %v", document, path, err)
+			continue
+		}
+		result = append(result, k8sObjectContainers(object)...)
+	}
+	return result, nil
+}
+
+func isManifestFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml" || ext == ".json"
+}
+
+func KubernetesDiscoveryFunc(config Config) ([]Container, error) {
+	if len(config.DiscoveryPaths) == 0 {
+		return nil, NewError(ErrorKinds.Parse, "Kubernetes discovery needs at least one manifest file or directory path", nil)
+	}
+
+	result := []Container{}
+	for _, root := range config.DiscoveryPaths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Explicitly given files are always read, directories only yield manifests
+			if entry.IsDir() || (path != root && !isManifestFile(path)) {
+				return nil
+			}
+
+			containers, err := parseManifestFile(path)
+			if err != nil {
+				// Unrendered templates and other YAML should not stop the scan
+				log.Printf("Skipping %s: %v", path, err)
+				return nil
+			}
+			if config.Debug {
+				fmt.Printf("Manifest %s, Containers: %d\n", path, len(containers))
+			}
+			result = append(result, containers...)
+			return nil
+		})
+		if err != nil {
+			return nil, fileDiscoveryError("Error reading manifests", err)
+		}
+	}
+	return result, nil
+}
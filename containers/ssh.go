@@ -0,0 +1,85 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"time"
+)
+
+// Connection to a docker daemon through the stdin and stdout of
+// `ssh <host> docker system dial-stdio`, like the docker CLI does
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.stdout.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "command" }
+func (a commandAddr) String() string  { return string(a) }
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("local") }
+func (c *commandConn) RemoteAddr() net.Addr { return commandAddr(c.cmd.String()) }
+
+// Deadlines are not supported by pipes, requests are bounded by their context
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Returns a dialer for an ssh://[user@]host[:port] endpoint
+func sshDialer(endpoint string) (func(context.Context, string, string) (net.Conn, error), error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("ssh endpoint %s has no host", endpoint)
+	}
+
+	args := []string{}
+	if parsed.User != nil {
+		args = append(args, "-l", parsed.User.Username())
+	}
+	if parsed.Port() != "" {
+		args = append(args, "-p", parsed.Port())
+	}
+	args = append(args, "--", parsed.Hostname(), "docker", "system", "dial-stdio")
+
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		// Not bound to ctx, the connection outlives the request that dialed it
+		cmd := exec.Command("ssh", args...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+	}, nil
+}
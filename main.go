@@ -1,38 +1,62 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 
 	"github.com/mlofjard/contrack/command"
 	"github.com/mlofjard/contrack/configuration"
 	"github.com/mlofjard/contrack/containers"
+	"github.com/mlofjard/contrack/metrics"
 	"github.com/mlofjard/contrack/mocks"
+	"github.com/mlofjard/contrack/notify"
+	"github.com/mlofjard/contrack/output"
 	"github.com/mlofjard/contrack/registry"
+	"github.com/mlofjard/contrack/state"
 	. "github.com/mlofjard/contrack/types"
 )
 
-func toggleMock[K ConfigFileReaderFn | ContainerDiscoveryFn | RegistryTagFetcherFn](has bool, mockFn K, realFn K) K {
+type pipelineFns struct {
+	containerDiscoveryFn      ContainerDiscoveryFn
+	registryTagFetcherFn      RegistryTagFetcherFn
+	registryDigestFetcherFn   RegistryDigestFetcherFn
+	registryPlatformFetcherFn RegistryPlatformFetcherFn
+}
+
+func toggleMock[K ConfigFileReaderFn | ContainerDiscoveryFn | RegistryTagFetcherFn | RegistryDigestFetcherFn | RegistryPlatformFetcherFn](has bool, mockFn K, realFn K) K {
 	if has {
 		return mockFn
 	}
 	return realFn
 }
 
-func main() {
-	// Setup and parse command flags
-	cmdFlags, mockFlags := command.SetupCommandline()
-	configFileReaderFn := toggleMock(mockFlags.Has("config"), mocks.ConfigFileReaderFunc, configuration.FileReaderFunc)
-	containerDiscoveryFn := toggleMock(mockFlags.Has("containers"), mocks.ContainerDiscoveryFunc, containers.DiscoveryFunc)
-	registryTagFetcherFn := toggleMock(mockFlags.Has("registry"), mocks.RegistryTagFetcherFunc, registry.TagFetcherFunc)
+// Intermediate and final results of one pipeline run
+type pipelineRun struct {
+	trackedContainers TrackedContainers
+	imageTagMap       ImageTagMap
+	results           []ContainerResult
+	started           time.Time
+	duration          time.Duration
+}
 
-	// Parse config file to domain -> repo map
-	domainConfiguredRegistryMap := make(DomainConfiguredRegistryMap)
-	var config Config
-	config = configuration.ParseConfigFile(&cmdFlags, domainConfiguredRegistryMap, configFileReaderFn)
+func (r pipelineRun) metrics() metrics.Run {
+	return metrics.Run{Results: r.results, ImageTagMap: r.imageTagMap, Started: r.started, Duration: r.duration}
+}
+
+// Runs discovery, grouping, tag fetching and processing once
+func runPipeline(config Config, domainConfiguredRegistryMap DomainConfiguredRegistryMap, fns pipelineFns) (pipelineRun, error) {
+	started := time.Now()
 
 	// Process containers and get domain -> grouped by repo map
-	var trackedContainers TrackedContainers
-	trackedContainers = containers.GetContainers(config, domainConfiguredRegistryMap, containerDiscoveryFn)
+	trackedContainers, err := containers.GetContainers(config, domainConfiguredRegistryMap, fns.containerDiscoveryFn)
+	if err != nil {
+		return pipelineRun{}, err
+	}
 
 	// Group containers by repo
 	domainGroupedRepoMap := make(DomainGroupedRepoMap, len(domainConfiguredRegistryMap))
@@ -40,10 +64,91 @@ func main() {
 
 	// Fetch tags for all unique images
 	imageTagMap := make(ImageTagMap, uniqueImagesCount)
-	registry.FetchTags(config, imageTagMap, domainGroupedRepoMap, domainConfiguredRegistryMap, uniqueImagesCount, registryTagFetcherFn)
+	registry.FetchTags(config, imageTagMap, domainGroupedRepoMap, domainConfiguredRegistryMap, uniqueImagesCount, fns.registryTagFetcherFn, fns.registryDigestFetcherFn)
+
+	// Process container image versions
+	platformChecker := registry.NewPlatformChecker(config, domainGroupedRepoMap, domainConfiguredRegistryMap, fns.registryPlatformFetcherFn)
+	results := containers.ProcessTrackedContainers(config, imageTagMap, trackedContainers, platformChecker.Supports)
+
+	return pipelineRun{
+		trackedContainers: trackedContainers,
+		imageTagMap:       imageTagMap,
+		results:           results,
+		started:           started,
+		duration:          time.Since(started),
+	}, nil
+}
+
+func main() {
+	// Setup and parse command flags
+	cmdFlags, mockFlags := command.SetupCommandline()
+	configFileReaderFn := toggleMock(mockFlags.Has("config"), mocks.ConfigFileReaderFunc, configuration.FileReaderFunc)
+
+	// Parse config file to domain -> repo map
+	domainConfiguredRegistryMap := make(DomainConfiguredRegistryMap)
+	config, err := configuration.ParseConfigFile(&cmdFlags, domainConfiguredRegistryMap, configFileReaderFn)
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(ExitFatal)
+	}
 
-	// Process container image versions and print
-	containers.ProcessTrackedContainers(config, imageTagMap, trackedContainers)
+	fns := pipelineFns{
+		containerDiscoveryFn:      toggleMock(mockFlags.Has("containers"), mocks.ContainerDiscoveryFunc, containers.DiscoveryFuncs[config.Discovery]),
+		registryTagFetcherFn:      toggleMock(mockFlags.Has("registry"), mocks.RegistryTagFetcherFunc, registry.TagFetcherFunc),
+		registryDigestFetcherFn:   toggleMock(mockFlags.Has("registry"), mocks.RegistryDigestFetcherFunc, registry.DigestFetcherFunc),
+		registryPlatformFetcherFn: toggleMock(mockFlags.Has("registry"), mocks.RegistryPlatformFetcherFunc, registry.PlatformFetcherFunc),
+	}
+
+	// Load previously reported updates
+	updateState, err := state.Load(config.StateFile)
+	if err != nil {
+		log.Fatalf("Error loading state: %v", err)
+	}
+
+	switch config.Command {
+	case "watch":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		watch(ctx, config, domainConfiguredRegistryMap, fns, updateState, nil, nil)
+		stop()
+		os.Exit(ExitOk)
+	case "serve":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		serve(ctx, config, domainConfiguredRegistryMap, fns, updateState)
+		stop()
+		os.Exit(ExitOk)
+	}
+
+	run, err := runPipeline(config, domainConfiguredRegistryMap, fns)
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(ExitFatal)
+	}
+	results := run.results
+	updateState.Apply(results, time.Now())
+	notify.Notify(config, results)
+	if err := updateState.Save(); err != nil {
+		log.Printf("Error saving state: %v", err)
+	}
+	if config.MetricsFile != "" {
+		if err := metrics.WriteTextfile(config.MetricsFile, run.metrics()); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	}
+
+	// Errors are never new, so --fail-on has to see the results before --only-new filters them
+	exitCode := command.ExitCode(config, results)
+	if config.OnlyNew {
+		results = slices.DeleteFunc(slices.Clone(results), func(r ContainerResult) bool { return !r.New })
+	}
+
+	// Print results in the configured format
+	renderer, err := output.NewRenderer(config)
+	if err != nil {
+		log.Fatalf("Error creating output: %v", err)
+	}
+	if err := renderer.Render(os.Stdout, results); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
 
-	os.Exit(0)
+	os.Exit(exitCode)
 }
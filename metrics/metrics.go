@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Results of one pipeline run, as exposed to Prometheus
+type Run struct {
+	Results     []ContainerResult
+	ImageTagMap ImageTagMap
+	Started     time.Time
+	Duration    time.Duration
+}
+
+type sample struct {
+	labels [][2]string
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	samples []sample
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (f family) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", f.name)
+	for _, s := range f.samples {
+		value := strconv.FormatFloat(s.value, 'f', -1, 64)
+		pairs := make([]string, len(s.labels))
+		for idx, label := range s.labels {
+			pairs[idx] = fmt.Sprintf("%s=\"%s\"", label[0], labelEscaper.Replace(label[1]))
+		}
+		if len(pairs) > 0 {
+			fmt.Fprintf(w, "%s{%s} %s\n", f.name, strings.Join(pairs, ","), value)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", f.name, value)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Writes the run in the Prometheus text exposition format
+func Write(w io.Writer, run Run) error {
+	updates := family{name: "contrack_update_available", help: "Whether a newer tag is available for the container image."}
+	errors := family{name: "contrack_container_error", help: "Whether the container could not be checked."}
+	for _, result := range run.Results {
+		updates.samples = append(updates.samples, sample{
+			labels: [][2]string{{"container", result.Container}, {"host", result.Host}, {"repository", result.Repository}, {"current", result.Tag}, {"latest", result.Update}},
+			value:  boolValue(result.Update != ""),
+		})
+		code := ""
+		if result.Error != nil {
+			code = result.Error.Code
+		}
+		errors.samples = append(errors.samples, sample{
+			labels: [][2]string{{"container", result.Container}, {"host", result.Host}, {"repository", result.Repository}, {"code", code}},
+			value:  boolValue(result.Status != "OK"),
+		})
+	}
+
+	registryErrors := family{name: "contrack_registry_errors", help: "Number of repositories whose tags could not be fetched in the last run."}
+	durations := family{name: "contrack_registry_fetch_duration_seconds", help: "Time spent fetching tags for the repository in the last run."}
+	errorCounts := make(map[string]int)
+	for _, repository := range slices.Sorted(maps.Keys(run.ImageTagMap)) {
+		imageTags := run.ImageTagMap[repository]
+		domain, _, _ := strings.Cut(repository, "/")
+		if _, ok := errorCounts[domain]; !ok {
+			errorCounts[domain] = 0
+		}
+		if imageTags.Err != nil {
+			errorCounts[domain]++
+		}
+		durations.samples = append(durations.samples, sample{
+			labels: [][2]string{{"domain", domain}, {"repository", repository}},
+			value:  imageTags.Duration.Seconds(),
+		})
+	}
+	for _, domain := range slices.Sorted(maps.Keys(errorCounts)) {
+		registryErrors.samples = append(registryErrors.samples, sample{
+			labels: [][2]string{{"domain", domain}},
+			value:  float64(errorCounts[domain]),
+		})
+	}
+
+	lastRun := family{name: "contrack_last_run_timestamp_seconds", help: "Unix time the last run started."}
+	lastRun.samples = []sample{{value: float64(run.Started.Unix())}}
+	runDuration := family{name: "contrack_last_run_duration_seconds", help: "Time the last run took."}
+	runDuration.samples = []sample{{value: run.Duration.Seconds()}}
+
+	buffered := bufio.NewWriter(w)
+	for _, f := range []family{updates, errors, registryErrors, durations, lastRun, runDuration} {
+		f.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// Writes the run as a node_exporter textfile. The file is replaced
+// atomically so the collector never reads a partial file.
+func WriteTextfile(path string, run Run) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := Write(tmp, run); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Temp files are created 0600, the collector may run as another user
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Serves the metrics of the latest run at /metrics
+type Server struct {
+	mutex sync.RWMutex
+	run   *Run
+}
+
+func (s *Server) Update(run Run) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.run = &run
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	// Nothing to report until the first run has finished
+	if s.run == nil {
+		http.Error(w, "no run finished yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := Write(w, *s.run); err != nil {
+		log.Printf("Error writing metrics: %v", err)
+	}
+}
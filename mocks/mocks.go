@@ -1,12 +1,13 @@
 package mocks
 
 import (
+	"crypto/tls"
 	"time"
 
 	. "github.com/mlofjard/contrack/types"
 )
 
-func ConfigFileReaderFunc(cmdFlags *CommandFlags) []byte {
+func ConfigFileReaderFunc(cmdFlags *CommandFlags) ([]byte, error) {
 	yaml := `
 ---
 registries:
@@ -16,12 +17,12 @@ registries:
     domain: docker.io
     
 `
-	return []byte(yaml)
+	return []byte(yaml), nil
 }
 
 type labelMap = map[string]string
 
-func ContainerDiscoveryFunc(config Config) []Container {
+func ContainerDiscoveryFunc(config Config) ([]Container, error) {
 	images := []Container{
 		{
 			Name:  "jellyfin-ctr",
@@ -32,6 +33,12 @@ func ContainerDiscoveryFunc(config Config) []Container {
 				"contrack.transform": "^(\\d+\\.\\d+\\.\\d+)ubu\\d+-ls(\\d+)$ => $1-$2",
 			},
 		},
+		{
+			Name:    "whoami-ctr",
+			Image:   "ghcr.io/traefik/whoami:latest",
+			Labels:  labelMap{},
+			Digests: []string{"ghcr.io/traefik/whoami@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+		},
 		{
 			Name:  "wud-ctr",
 			Image: "ghcr.io/getwud/wud:1.2.3",
@@ -50,10 +57,10 @@ func ContainerDiscoveryFunc(config Config) []Container {
 		},
 	}
 
-	return images
+	return images, nil
 }
 
-func RegistryTagFetcherFunc(regUrl string, authType AuthType, authToken string, image string, tags *TagList, last string) int {
+func RegistryTagFetcherFunc(regUrl string, tlsConfig *tls.Config, authType AuthType, authToken string, image string, tags *TagList) int {
 	tags.Tags = []string{
 		"2.0.0ubu2404-ls254",
 		"1.0.0ubu2204-ls22",
@@ -70,3 +77,15 @@ func RegistryTagFetcherFunc(regUrl string, authType AuthType, authToken string,
 	time.Sleep(1 * time.Second)
 	return 200
 }
+
+func RegistryDigestFetcherFunc(regUrl string, tlsConfig *tls.Config, authType AuthType, authToken string, image string, tag string) (string, int) {
+	return "sha256:1111111111111111111111111111111111111111111111111111111111111111", 200
+}
+
+func RegistryPlatformFetcherFunc(regUrl string, tlsConfig *tls.Config, authType AuthType, authToken string, image string, tag string) ([]string, int) {
+	// Pretend the newest major release has not been built for arm yet
+	if tag == "2.0.0" {
+		return []string{"linux/amd64"}, 200
+	}
+	return []string{"linux/amd64", "linux/arm64/v8", "linux/arm/v7"}, 200
+}
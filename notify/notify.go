@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"text/template"
+	"time"
+
+	. "github.com/mlofjard/contrack/types"
+
+	"github.com/go-resty/resty/v2"
+)
+
+type Update struct {
+	Container  string `json:"container"`
+	Image      string `json:"image"`
+	Repository string `json:"repository"`
+	CurrentTag string `json:"currentTag"`
+	NewTag     string `json:"newTag"`
+}
+
+// Body templates for chat services with incoming webhooks
+var FormatTemplates = map[string]string{
+	"slack":      `{"text": {{ printf "%s (%s) can be updated from %s to %s" .Container .Repository .CurrentTag .NewTag | json }}}`,
+	"mattermost": `{"text": {{ printf "%s (%s) can be updated from %s to %s" .Container .Repository .CurrentTag .NewTag | json }}}`,
+	"discord":    `{"content": {{ printf "%s (%s) can be updated from %s to %s" .Container .Repository .CurrentTag .NewTag | json }}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Parses the body template of a notifier, a nil template sends the update as JSON
+func ParseTemplate(notifier NotifierConfig) (*template.Template, error) {
+	body := notifier.Template
+	if body == "" {
+		body = FormatTemplates[notifier.Format]
+	}
+	if body == "" {
+		return nil, nil
+	}
+	return template.New(notifier.Name).Funcs(templateFuncs).Parse(body)
+}
+
+func renderBody(notifier NotifierConfig, update Update) ([]byte, error) {
+	if notifier.Body == nil {
+		return json.Marshal(update)
+	}
+	buffer := &bytes.Buffer{}
+	if err := notifier.Body.Execute(buffer, update); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Bounds a webhook call, so a receiver that never answers can't hold up the
+// checks in watch mode
+var sendTimeout = 15 * time.Second
+
+func send(notifier NotifierConfig, update Update) error {
+	body, err := renderBody(notifier, update)
+	if err != nil {
+		return fmt.Errorf("rendering body: %w", err)
+	}
+
+	method := notifier.Method
+	if method == "" {
+		method = resty.MethodPost
+	}
+	resp, err := resty.New().SetTimeout(sendTimeout).R().
+		SetHeader("content-type", "application/json").
+		SetHeaders(notifier.Headers).
+		SetBody(body).
+		Execute(method, notifier.Url)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// Sends every new update in the results to all configured notifiers
+func Notify(config Config, results []ContainerResult) {
+	for _, result := range results {
+		if result.Update == "" || !result.New {
+			continue
+		}
+		update := Update{
+			Container:  result.Container,
+			Image:      result.Image,
+			Repository: result.Repository,
+			CurrentTag: result.Tag,
+			NewTag:     result.Update,
+		}
+		for _, notifier := range config.Notifiers {
+			if config.Debug {
+				fmt.Printf("Notifying %s about %s\n", notifier.Name, result.Container)
+			}
+			if err := send(notifier, update); err != nil {
+				log.Printf("Notifier %s failed for %s: %v", notifier.Name, result.Container, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+type receivedRequest struct {
+	method      string
+	contentType string
+	token       string
+	body        []byte
+}
+
+// Starts a webhook receiver that records every request it gets
+func newReceiver(t *testing.T) (*httptest.Server, *[]receivedRequest) {
+	t.Helper()
+	requests := &[]receivedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		}
+		*requests = append(*requests, receivedRequest{
+			method:      r.Method,
+			contentType: r.Header.Get("Content-Type"),
+			token:       r.Header.Get("X-Token"),
+			body:        body,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newNotifier(t *testing.T, notifier NotifierConfig) NotifierConfig {
+	t.Helper()
+	body, err := ParseTemplate(notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.Body = body
+	return notifier
+}
+
+var results = []ContainerResult{
+	{Container: "web", Image: "docker.io/library/nginx:1.25.0", Repository: "docker.io/library/nginx", Tag: "1.25.0", Update: "1.27.0", New: true},
+	// Already notified about and up to date containers are not sent
+	{Container: "db", Image: "docker.io/library/postgres:16.1", Repository: "docker.io/library/postgres", Tag: "16.1", Update: "16.2"},
+	{Container: "cache", Image: "docker.io/library/redis:7.2", Repository: "docker.io/library/redis", Tag: "7.2"},
+}
+
+func TestNotifyDefaultPayload(t *testing.T) {
+	server, requests := newReceiver(t)
+	notifier := newNotifier(t, NotifierConfig{Name: "default", Url: server.URL, Headers: map[string]string{"X-Token": "secret"}})
+
+	Notify(Config{Notifiers: []NotifierConfig{notifier}}, results)
+
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	request := (*requests)[0]
+	if request.method != http.MethodPost {
+		t.Errorf("method = %s, want POST", request.method)
+	}
+	if request.contentType != "application/json" {
+		t.Errorf("content type = %q, want application/json", request.contentType)
+	}
+	if request.token != "secret" {
+		t.Errorf("configured header = %q, want secret", request.token)
+	}
+
+	payload := map[string]string{}
+	if err := json.Unmarshal(request.body, &payload); err != nil {
+		t.Fatalf("body %s is not JSON: %v", request.body, err)
+	}
+	want := map[string]string{
+		"container":  "web",
+		"image":      "docker.io/library/nginx:1.25.0",
+		"repository": "docker.io/library/nginx",
+		"currentTag": "1.25.0",
+		"newTag":     "1.27.0",
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("%s = %q, want %q", key, payload[key], value)
+		}
+	}
+}
+
+func TestNotifyCustomTemplate(t *testing.T) {
+	server, requests := newReceiver(t)
+	notifier := newNotifier(t, NotifierConfig{
+		Name:     "custom",
+		Url:      server.URL,
+		Method:   http.MethodPut,
+		Template: `{"message": {{ printf "%s: %s to %s" .Container .CurrentTag .NewTag | json }}}`,
+	})
+
+	Notify(Config{Notifiers: []NotifierConfig{notifier}}, results)
+
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	request := (*requests)[0]
+	if request.method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", request.method)
+	}
+	if got, want := string(request.body), `{"message": "web: 1.25.0 to 1.27.0"}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestParseTemplateError(t *testing.T) {
+	if _, err := ParseTemplate(NotifierConfig{Name: "broken", Template: "{{ .Container "}); err == nil {
+		t.Fatal("expected an error for an unclosed action")
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { sendTimeout = timeout }(sendTimeout)
+	sendTimeout = 50 * time.Millisecond
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	// Cleanups run last in first out, the handler has to return before the server closes
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(done) })
+
+	notifier := newNotifier(t, NotifierConfig{Name: "slow", Url: server.URL})
+	start := time.Now()
+	if err := send(notifier, Update{Container: "web"}); err == nil {
+		t.Fatal("expected an error for a webhook that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("send returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,159 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	. "github.com/mlofjard/contrack/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Renderer interface {
+	Render(io.Writer, []ContainerResult) error
+}
+
+// Available output formats
+var Formats = []string{"table", "json", "yaml", "csv"}
+
+func NewRenderer(config Config) (Renderer, error) {
+	switch config.Output {
+	case "", "table":
+		emptyMessage := "No containers found"
+		if config.OnlyNew {
+			emptyMessage = "No new updates found"
+		}
+		return Table{Columns: config.Columns, EmptyMessage: emptyMessage}, nil
+	case "json":
+		return Json{}, nil
+	case "yaml":
+		return Yaml{}, nil
+	case "csv":
+		return Csv{Columns: config.Columns}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected one of %s", config.Output, strings.Join(Formats, ", "))
+}
+
+func columnValue(result ContainerResult, column string) string {
+	switch column {
+	case "container":
+		return result.Container
+	case "host":
+		return result.Host
+	case "status":
+		return result.Status
+	case "detail":
+		return result.Detail
+	case "repository":
+		return result.Repository
+	case "image":
+		return result.Image
+	case "domain":
+		return result.Domain
+	case "path":
+		return result.Path
+	case "tag":
+		return result.Tag
+	case "update":
+		return result.Update
+	case "latest":
+		return result.Latest
+	case "platform":
+		return result.Platform
+	case "digest":
+		return result.Digest
+	case "age":
+		return formatAge(result.UpdateSince)
+	}
+	return ""
+}
+
+// Formats the time since an update was first found, like 3d4h or 25m
+func formatAge(since *time.Time) string {
+	if since == nil {
+		return ""
+	}
+	age := time.Since(*since)
+	days := int(age.Hours()) / 24
+	hours := int(age.Hours()) % 24
+	minutes := int(age.Minutes()) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+func mapOutput(columns []string, result ContainerResult) []string {
+	var output = make([]string, len(columns))
+	for idx, column := range columns {
+		output[idx] = columnValue(result, column)
+	}
+	return output
+}
+
+func newReport(results []ContainerResult) Report {
+	if results == nil {
+		results = []ContainerResult{}
+	}
+	return Report{SchemaVersion: ReportSchemaVersion, Containers: results}
+}
+
+type Table struct {
+	Columns      []string
+	EmptyMessage string
+}
+
+func (r Table) Render(writer io.Writer, results []ContainerResult) error {
+	if len(results) == 0 {
+		_, err := fmt.Fprintln(writer, r.EmptyMessage)
+		return err
+	}
+
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(r.Columns, "\t")))
+	for _, result := range results {
+		fmt.Fprintln(w, strings.Join(mapOutput(r.Columns, result), "\t"))
+	}
+	return w.Flush()
+}
+
+type Csv struct {
+	Columns []string
+}
+
+func (r Csv) Render(writer io.Writer, results []ContainerResult) error {
+	w := csv.NewWriter(writer)
+	w.Write(r.Columns)
+	for _, result := range results {
+		w.Write(mapOutput(r.Columns, result))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+type Json struct{}
+
+func (r Json) Render(writer io.Writer, results []ContainerResult) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newReport(results))
+}
+
+type Yaml struct{}
+
+func (r Yaml) Render(writer io.Writer, results []ContainerResult) error {
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(newReport(results)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+type authChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// Parses a WWW-Authenticate header value like
+// Bearer realm="https://auth.example.com/token",service="registry.example.com"
+func parseChallenge(header string) (authChallenge, bool) {
+	header = strings.TrimSpace(header)
+	scheme, rest, _ := strings.Cut(header, " ")
+	if scheme == "" {
+		return authChallenge{}, false
+	}
+
+	challenge := authChallenge{Scheme: scheme, Params: make(map[string]string)}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, "\"") {
+			// Quoted values can contain commas and escaped characters
+			var sb strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				sb.WriteByte(value[i])
+			}
+			challenge.Params[key] = sb.String()
+			rest = value[min(i+1, len(value)):]
+		} else {
+			v, r, _ := strings.Cut(value, ",")
+			challenge.Params[key] = strings.TrimSpace(v)
+			rest = "," + r
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return challenge, true
+}
+
+// Probes the registry base url for an authentication challenge. A nil
+// challenge means the registry allows anonymous access.
+func probeChallenge(regUrl string, tlsConfig *tls.Config) (*authChallenge, error) {
+	resp, err := newClient(tlsConfig).R().Get(strings.TrimSuffix(regUrl, "/") + "/")
+	if err != nil {
+		return nil, NewError(ErrorKinds.Network, "Registry could not be reached", err)
+	}
+	if resp.StatusCode() != 401 {
+		return nil, nil
+	}
+	for _, header := range resp.Header().Values("www-authenticate") {
+		if challenge, ok := parseChallenge(header); ok {
+			return &challenge, nil
+		}
+	}
+	return nil, NewError(ErrorKinds.Auth, fmt.Sprintf("Registry %s responded 401 without a challenge", regUrl), nil)
+}
+
+// Fetches a token from the challenge realm scoped to pull all paths in the
+// grouped repository, sending the configured credentials if there are any
+func fetchToken(regUrl string, tlsConfig *tls.Config, challenge authChallenge, rg GroupedRepository, authType AuthType, token string) (string, error) {
+	realm, ok := challenge.Params["realm"]
+	if !ok {
+		return "", NewError(ErrorKinds.Auth, "Bearer challenge without realm", nil)
+	}
+
+	query := url.Values{}
+	if service, ok := challenge.Params["service"]; ok {
+		query.Set("service", service)
+	}
+	for _, path := range rg.Paths {
+		query.Add("scope", fmt.Sprintf("repository:%s:pull", path))
+	}
+	if len(rg.Paths) == 0 && challenge.Params["scope"] != "" {
+		query.Set("scope", challenge.Params["scope"])
+	}
+
+	// The realm is usually another host, which gets the default TLS config
+	client := newClient(realmTLS(regUrl, realm, tlsConfig)).SetHeader("accept", "application/json")
+	if authType != AuthTypes.None {
+		client.SetAuthScheme(authType.Scheme)
+		client.SetAuthToken(token)
+	}
+
+	resp, err := client.R().
+		SetQueryParamsFromValues(query).
+		Get(realm)
+	if err != nil {
+		return "", NewError(ErrorKinds.Network, "Token endpoint could not be reached", err)
+	}
+	if resp.StatusCode() != 200 {
+		kind := ErrorKinds.Auth
+		if resp.StatusCode() == 429 {
+			kind = ErrorKinds.RateLimited
+		}
+		return "", NewError(kind, fmt.Sprintf("Token endpoint %s responded %d", realm, resp.StatusCode()), nil)
+	}
+
+	// Not all token endpoints send a JSON content type, so decode explicitly
+	tokenResponse := &tokenResponse{}
+	if err := json.Unmarshal(resp.Body(), tokenResponse); err != nil {
+		return "", NewError(ErrorKinds.Parse, fmt.Sprintf("Token endpoint %s returned an unreadable token", realm), err)
+	}
+
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	if tokenResponse.AccessToken != "" {
+		return tokenResponse.AccessToken, nil
+	}
+	return "", NewError(ErrorKinds.Auth, fmt.Sprintf("Token endpoint %s returned no token", realm), nil)
+}
+
+// Standard registry token authentication. Probes /v2/ and exchanges the
+// configured credentials for a scoped token when a Bearer challenge is
+// returned. Registries without a challenge get the configured credentials.
+func challengeAuth(regUrl string, tlsConfig *tls.Config, rg GroupedRepository, authType AuthType, token string) (string, AuthType, error) {
+	challenge, err := probeChallenge(regUrl, tlsConfig)
+	if err != nil {
+		return token, authType, err
+	}
+	if challenge == nil || !strings.EqualFold(challenge.Scheme, "bearer") {
+		return token, authType, nil
+	}
+
+	bearerToken, err := fetchToken(regUrl, tlsConfig, *challenge, rg, authType, token)
+	if err != nil {
+		return token, authType, err
+	}
+	return bearerToken, AuthTypes.Bearer, nil
+}
@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   authChallenge
+		ok     bool
+	}{
+		{
+			name:   "multiple params",
+			header: `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:app:pull"`,
+			want: authChallenge{Scheme: "Bearer", Params: map[string]string{
+				"realm":   "https://auth.example.com/token",
+				"service": "registry.example.com",
+				"scope":   "repository:app:pull",
+			}},
+			ok: true,
+		},
+		{
+			name:   "quoted commas and escapes",
+			header: `Bearer realm="https://auth.example.com/token",scope="repository:a:pull,push",error="say \"hi\""`,
+			want: authChallenge{Scheme: "Bearer", Params: map[string]string{
+				"realm": "https://auth.example.com/token",
+				"scope": "repository:a:pull,push",
+				"error": `say "hi"`,
+			}},
+			ok: true,
+		},
+		{
+			name:   "unquoted values and spacing",
+			header: `Basic  Realm=registry , charset="UTF-8"`,
+			want:   authChallenge{Scheme: "Basic", Params: map[string]string{"realm": "registry", "charset": "UTF-8"}},
+			ok:     true,
+		},
+		{
+			name:   "missing realm",
+			header: `Bearer service="registry.example.com"`,
+			want:   authChallenge{Scheme: "Bearer", Params: map[string]string{"service": "registry.example.com"}},
+			ok:     true,
+		},
+		{
+			name:   "empty",
+			header: "",
+			ok:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseChallenge(test.header)
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if ok && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFetchTokenWithoutRealm(t *testing.T) {
+	challenge := authChallenge{Scheme: "Bearer", Params: map[string]string{"service": "registry.example.com"}}
+	_, err := fetchToken("https://registry.example.com/v2", nil, challenge, GroupedRepository{Paths: []string{"app"}}, AuthTypes.None, "")
+	if ErrorKindOf(err) != ErrorKinds.Auth {
+		t.Fatalf("got error %v, want an auth error", err)
+	}
+}
+
+func TestChallengeAuth(t *testing.T) {
+	credentials := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			if got := r.Header.Get("Authorization"); got != "Basic "+credentials {
+				t.Errorf("token request authorization = %q, want Basic credentials", got)
+			}
+			if got := r.URL.Query().Get("service"); got != "registry.test" {
+				t.Errorf("service = %q, want registry.test", got)
+			}
+			wantScopes := []string{"repository:team/app:pull", "repository:team/db:pull"}
+			if got := r.URL.Query()["scope"]; !reflect.DeepEqual(got, wantScopes) {
+				t.Errorf("scopes = %v, want %v", got, wantScopes)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"token":"pull-token"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	rg := GroupedRepository{Domain: "registry.test", Paths: []string{"team/app", "team/db"}}
+	token, authType, err := challengeAuth(server.URL+"/v2", nil, rg, AuthTypes.Basic, credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "pull-token" || authType != AuthTypes.Bearer {
+		t.Errorf("got %q %v, want the bearer pull token", token, authType)
+	}
+}
+
+func TestChallengeAuthWithoutChallenge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	_, _, err := challengeAuth(server.URL+"/v2", nil, GroupedRepository{}, AuthTypes.None, "")
+	if ErrorKindOf(err) != ErrorKinds.Auth {
+		t.Fatalf("got error %v, want an auth error", err)
+	}
+}
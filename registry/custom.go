@@ -1,6 +1,8 @@
 package registry
 
 import (
+	"crypto/tls"
+
 	. "github.com/mlofjard/contrack/types"
 )
 
@@ -12,6 +14,11 @@ func (r Custom) GetUrl() string {
 	return r.RegistryUrl
 }
 
-func (r Custom) GetAuth(rg GroupedRepository, authType AuthType, token string) (string, AuthType) {
-	return token, authType
+func (r Custom) GetAuth(rg GroupedRepository, authType AuthType, token string, tlsConfig *tls.Config) (string, AuthType, error) {
+	// A configured bearer token is used as is
+	if authType == AuthTypes.Bearer {
+		return token, authType, nil
+	}
+
+	return challengeAuth(r.RegistryUrl, tlsConfig, rg, authType, token)
 }
@@ -1,6 +1,8 @@
 package registry
 
 import (
+	"crypto/tls"
+
 	. "github.com/mlofjard/contrack/types"
 )
 
@@ -12,10 +14,14 @@ func (r Ghcr) GetUrl() string {
 	return r.registryUrl
 }
 
-func (r Ghcr) GetAuth(rg GroupedRepository, authType AuthType, token string) (string, AuthType) {
+func (r Ghcr) GetAuth(rg GroupedRepository, authType AuthType, token string, tlsConfig *tls.Config) (string, AuthType, error) {
+	if authType == AuthTypes.Basic {
+		// Exchange credentials, e.g. from docker login, for a pull token
+		return challengeAuth(r.registryUrl, tlsConfig, rg, authType, token)
+	}
 	if authType != AuthTypes.None {
-		return token, authType
+		return token, authType, nil
 	}
 	// Base64 of ":" is their "anonymous" bearer token
-	return "Og==", AuthTypes.Bearer
+	return "Og==", AuthTypes.Bearer, nil
 }
@@ -1,6 +1,8 @@
 package registry
 
 import (
+	"crypto/tls"
+
 	. "github.com/mlofjard/contrack/types"
 )
 
@@ -12,6 +14,6 @@ func (r Lscr) GetUrl() string {
 	return r.registryUrl
 }
 
-func (r Lscr) GetAuth(rg GroupedRepository, authType AuthType, token string) (string, AuthType) {
-	return token, authType
+func (r Lscr) GetAuth(rg GroupedRepository, authType AuthType, token string, tlsConfig *tls.Config) (string, AuthType, error) {
+	return token, authType, nil
 }
@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+func TestParseNextLink(t *testing.T) {
+	requestUrl, _ := url.Parse("https://registry.lan/v2/app/tags/list?n=2")
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{header: `</v2/app/tags/list?last=b&n=2>; rel="next"`, want: "https://registry.lan/v2/app/tags/list?last=b&n=2", ok: true},
+		{header: `<https://cdn.registry.lan/v2/app/tags/list?last=b>; rel=next`, want: "https://cdn.registry.lan/v2/app/tags/list?last=b", ok: true},
+		{header: `<list?last=b>; rel="next"`, want: "https://registry.lan/v2/app/tags/list?last=b", ok: true},
+		{header: `</v2/app/tags/list?n=2>; rel="prev", </v2/app/tags/list?last=d>; REL="last next"`, want: "https://registry.lan/v2/app/tags/list?last=d", ok: true},
+		{header: `</v2/app/tags/list?n=2>; rel="prev"`},
+		{header: `/v2/app/tags/list?last=b; rel="next"`},
+		{header: `</v2/app/tags/list?last=b>`},
+		{header: ""},
+	}
+	for _, test := range tests {
+		got, ok := parseNextLink(test.header, requestUrl)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseNextLink(%q) = %q, %v, want %q, %v", test.header, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestTagFetcherPagination(t *testing.T) {
+	pages := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	tests := []struct {
+		name string
+		// Link header of every page but the last
+		link      func(base string, page int) string
+		maxPages  int
+		want      []string
+		requests  int32
+		truncated bool
+	}{
+		{
+			name: "relative next",
+			link: func(base string, page int) string {
+				return fmt.Sprintf(`</v2/app/tags/list?page=%d>; rel="next"`, page+1)
+			},
+			want:     []string{"a", "b", "c", "d", "e"},
+			requests: 3,
+		},
+		{
+			name: "absolute next",
+			link: func(base string, page int) string {
+				return fmt.Sprintf(`<%s/v2/app/tags/list?page=%d>; rel="next"`, base, page+1)
+			},
+			want:     []string{"a", "b", "c", "d", "e"},
+			requests: 3,
+		},
+		{
+			name: "repeated next",
+			link: func(base string, page int) string {
+				return `</v2/app/tags/list?page=1>; rel="next"`
+			},
+			want:     []string{"a", "b", "c", "d"},
+			requests: 2,
+		},
+		{
+			name: "next back to the first page",
+			link: func(base string, page int) string {
+				if page == 1 {
+					return `</v2/app/tags/list?n=2>; rel="next"`
+				}
+				return `</v2/app/tags/list?page=1>; rel="next"`
+			},
+			want:     []string{"a", "b", "c", "d"},
+			requests: 2,
+		},
+		{
+			name: "max pages",
+			link: func(base string, page int) string {
+				return fmt.Sprintf(`</v2/app/tags/list?page=%d>; rel="next"`, page+1)
+			},
+			maxPages:  2,
+			want:      []string{"a", "b", "c", "d"},
+			requests:  2,
+			truncated: true,
+		},
+		{
+			name: "max pages not reached",
+			link: func(base string, page int) string {
+				return fmt.Sprintf(`</v2/app/tags/list?page=%d>; rel="next"`, page+1)
+			},
+			maxPages: 3,
+			want:     []string{"a", "b", "c", "d", "e"},
+			requests: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := &atomic.Int32{}
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				if page+1 < len(pages) {
+					w.Header().Set("Link", test.link(server.URL, page))
+				}
+				fmt.Fprintf(w, `{"name":"app","tags":["%s"]}`, strings.Join(pages[page], `","`))
+			}))
+			defer server.Close()
+
+			tags := &TagList{Tags: []string{}, PageSize: 2, MaxPages: test.maxPages}
+			if status := TagFetcherFunc(server.URL+"/v2", nil, AuthTypes.None, "", "app", tags); status != 200 {
+				t.Fatalf("status %d", status)
+			}
+			if !slices.Equal(tags.Tags, test.want) {
+				t.Errorf("got tags %v, want %v", tags.Tags, test.want)
+			}
+			if tags.Truncated != test.truncated {
+				t.Errorf("truncated = %v, want %v", tags.Truncated, test.truncated)
+			}
+			if got := requests.Load(); got != test.requests {
+				t.Errorf("got %d requests, want %d", got, test.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mlofjard/contrack/cache"
+	. "github.com/mlofjard/contrack/types"
+)
+
+type manifestPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant"`
+}
+
+type manifestResponse struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Platform *manifestPlatform `json:"platform"`
+	} `json:"manifests"`
+	Config *struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+func (p manifestPlatform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// Architectures as reported by uname, mapped to their OCI names
+var architectureAliases = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm/v7",
+	"armv6l":  "arm/v6",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// Normalizes an os/arch[/variant] platform, accepting uname style architectures
+func NormalizePlatform(platform string) string {
+	platform = strings.ToLower(strings.TrimSpace(platform))
+	os, arch, found := strings.Cut(platform, "/")
+	if !found {
+		return platform
+	}
+	arch, variant, _ := strings.Cut(arch, "/")
+	if alias, ok := architectureAliases[arch]; ok {
+		arch, variant, _ = strings.Cut(alias+"/"+variant, "/")
+		variant = strings.Trim(variant, "/")
+	}
+	if variant == "" {
+		return fmt.Sprintf("%s/%s", os, arch)
+	}
+	return fmt.Sprintf("%s/%s/%s", os, arch, variant)
+}
+
+// Returns whether a manifest platform can run on the wanted platform. A
+// missing variant on either side matches any variant.
+func platformMatches(wanted string, available string) bool {
+	wantedParts := strings.Split(NormalizePlatform(wanted), "/")
+	availableParts := strings.Split(NormalizePlatform(available), "/")
+	if len(wantedParts) < 2 || len(availableParts) < 2 {
+		return false
+	}
+	if wantedParts[0] != availableParts[0] || wantedParts[1] != availableParts[1] {
+		return false
+	}
+	return len(wantedParts) < 3 || len(availableParts) < 3 || wantedParts[2] == availableParts[2]
+}
+
+// Fetches the platforms a tag is available for. Manifest lists and OCI
+// indexes list them directly, single manifests name theirs in the config blob.
+func PlatformFetcherFunc(regUrl string, tlsConfig *tls.Config, authType AuthType, authToken string, image string, tag string) ([]string, int) {
+	client := newRetryClient(regUrl, tlsConfig).
+		SetHeader("accept", strings.Join(manifestAcceptHeaders, ", "))
+
+	if authType != AuthTypes.None {
+		client.SetAuthScheme(authType.Scheme)
+		client.SetAuthToken(authToken)
+	}
+
+	url := fmt.Sprintf("%s/%s/manifests/%s", regUrl, image, tag)
+	resp, err := client.R().Get(url)
+	if err != nil && (resp == nil || resp.StatusCode() == 0) {
+		return nil, -1
+	}
+	if resp.StatusCode() != 200 {
+		return nil, resp.StatusCode()
+	}
+	manifest := manifestResponse{}
+	if err := json.Unmarshal(resp.Body(), &manifest); err != nil {
+		return nil, -1
+	}
+
+	platforms := []string{}
+	if len(manifest.Manifests) > 0 {
+		for _, m := range manifest.Manifests {
+			// Attestation manifests have an unknown platform
+			if m.Platform != nil && m.Platform.OS != "unknown" {
+				platforms = append(platforms, m.Platform.String())
+			}
+		}
+		return platforms, 200
+	}
+	if manifest.Config == nil {
+		return platforms, 200
+	}
+
+	url = fmt.Sprintf("%s/%s/blobs/%s", regUrl, image, manifest.Config.Digest)
+	resp, err = client.R().Get(url)
+	if err != nil && (resp == nil || resp.StatusCode() == 0) {
+		return nil, -1
+	}
+	if resp.StatusCode() != 200 {
+		return nil, resp.StatusCode()
+	}
+	config := manifestPlatform{}
+	if err := json.Unmarshal(resp.Body(), &config); err != nil {
+		return nil, -1
+	}
+	return append(platforms, config.String()), 200
+}
+
+type registrySources struct {
+	sources []tagSource
+	err     error
+}
+
+// Checks whether tags are available for a platform. Registries and their
+// mirrors are authenticated on first use and answers are remembered for the run.
+type PlatformChecker struct {
+	config                      Config
+	platformCache               *cache.Cache
+	domainGroupedRepoMap        DomainGroupedRepoMap
+	domainConfiguredRegistryMap DomainConfiguredRegistryMap
+	fetcherFn                   RegistryPlatformFetcherFn
+	sources                     map[string]registrySources
+	platforms                   map[string][]string
+}
+
+func NewPlatformChecker(config Config, domainGroupedRepoMap DomainGroupedRepoMap, domainConfiguredRegistryMap DomainConfiguredRegistryMap, fetcherFn RegistryPlatformFetcherFn) *PlatformChecker {
+	return &PlatformChecker{
+		config:                      config,
+		platformCache:               cache.New(config.CacheDir, config.CacheTtl, config.Refresh),
+		domainGroupedRepoMap:        domainGroupedRepoMap,
+		domainConfiguredRegistryMap: domainConfiguredRegistryMap,
+		fetcherFn:                   fetcherFn,
+		sources:                     make(map[string]registrySources),
+		platforms:                   make(map[string][]string),
+	}
+}
+
+// Returns whether the tag is available for the platform. Tags whose
+// platforms can't be fetched are assumed to be available.
+func (c *PlatformChecker) Supports(domain string, path string, tag string, platform string) bool {
+	platforms, ok := c.fetchPlatforms(domain, path, tag)
+	// Nothing to compare with, like an index of attestations only
+	if !ok || len(platforms) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(platforms, func(available string) bool { return platformMatches(platform, available) })
+}
+
+// Returns the platforms of a tag from memory, the cache, a mirror or the
+// registry. Manifest requests count against pull quotas, so a source is
+// skipped like for tag fetches once its rate limit is reached.
+func (c *PlatformChecker) fetchPlatforms(domain string, path string, tag string) ([]string, bool) {
+	configuredRegistry, ok := c.domainConfiguredRegistryMap[domain]
+	if !ok {
+		return nil, false
+	}
+
+	key := fmt.Sprintf("%s/%s:%s", domain, path, tag)
+	if platforms, ok := c.platforms[key]; ok {
+		return platforms, true
+	}
+	// Kept apart from the tag lists, which are stored by <domain>/<path>
+	cacheKey := fmt.Sprintf("platforms/%s/%s/%s", domain, path, tag)
+	entry, cached, err := c.platformCache.Get(cacheKey)
+	if err != nil {
+		log.Printf("Ignoring platform cache for %s: %v", key, err)
+	}
+	if cached && c.platformCache.Fresh(entry, time.Now()) {
+		c.platforms[key] = entry.Platforms
+		return entry.Platforms, true
+	}
+
+	sources, ok := c.sources[domain]
+	if !ok {
+		found, err := tagSources(configuredRegistry, c.domainGroupedRepoMap[domain])
+		sources = registrySources{sources: found, err: err}
+		c.sources[domain] = sources
+	}
+	if sources.err != nil {
+		if c.config.Debug {
+			fmt.Printf("Platforms for %s unknown: %v\n", key, sources.err)
+		}
+		return nil, false
+	}
+
+	// Asked in the same order as the tags, the first source that answers wins
+	var platforms []string
+	status := 0
+	for _, source := range sources.sources {
+		if limit, ok := currentRateLimit(source.url); ok && limit.Remaining <= c.config.MinRateLimit {
+			if c.config.Debug {
+				fmt.Printf("Skipping %s for platforms of %s, rate limit remaining %d\n", source.url, key, limit.Remaining)
+			}
+			continue
+		}
+		platforms, status = c.fetcherFn(source.url, source.tlsConfig, source.authType, source.authToken, path, tag)
+		if c.config.Debug {
+			fmt.Printf("Platforms for %s from %s, Status: %d, Platforms: %v\n", key, source.url, status, platforms)
+		}
+		if status == 200 {
+			break
+		}
+	}
+	if status != 200 {
+		return nil, false
+	}
+	c.platforms[key] = platforms
+	if err := c.platformCache.Put(cacheKey, cache.Entry{Platforms: platforms, FetchedAt: time.Now()}); err != nil {
+		log.Printf("Error caching platforms for %s: %v", key, err)
+	}
+	return platforms, true
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Retries of requests answered with 429 or a server error
+const (
+	retryCount   = 3
+	retryWait    = time.Second
+	retryMaxWait = 30 * time.Second
+)
+
+type rateLimit struct {
+	Limit     int
+	Remaining int
+}
+
+// Latest rate limit reported by each registry url, reset at the start of every run
+var rateLimits = struct {
+	sync.Mutex
+	byUrl map[string]rateLimit
+}{byUrl: make(map[string]rateLimit)}
+
+// Parses rate limit header values like "76;w=21600"
+func parseRateLimitHeader(value string) (int, bool) {
+	count, _, _ := strings.Cut(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	return n, err == nil
+}
+
+func observeRateLimit(regUrl string, header http.Header) {
+	remaining, ok := parseRateLimitHeader(header.Get("ratelimit-remaining"))
+	if !ok {
+		return
+	}
+	limit, _ := parseRateLimitHeader(header.Get("ratelimit-limit"))
+
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	rateLimits.byUrl[regUrl] = rateLimit{Limit: limit, Remaining: remaining}
+}
+
+func resetRateLimit(regUrl string) {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	delete(rateLimits.byUrl, regUrl)
+}
+
+func currentRateLimit(regUrl string) (rateLimit, bool) {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	limit, ok := rateLimits.byUrl[regUrl]
+	return limit, ok
+}
+
+// Parses Retry-After as seconds or an HTTP date, zero when missing
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// Creates a client retrying 429 and server errors with exponential backoff,
+// waiting as long as Retry-After asks unless that is longer than retryMaxWait
+func newRetryClient(regUrl string, tlsConfig *tls.Config) *resty.Client {
+	return newClient(tlsConfig).
+		SetRetryCount(retryCount).
+		SetRetryWaitTime(retryWait).
+		SetRetryMaxWaitTime(retryMaxWait).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			return resp != nil && (resp.StatusCode() == 429 || resp.StatusCode() >= 500)
+		}).
+		SetRetryAfter(func(client *resty.Client, resp *resty.Response) (time.Duration, error) {
+			wait := parseRetryAfter(resp.Header().Get("retry-after"), time.Now())
+			if wait > retryMaxWait {
+				return 0, fmt.Errorf("registry asked to retry after %s", wait)
+			}
+			return wait, nil
+		}).
+		OnAfterResponse(func(client *resty.Client, resp *resty.Response) error {
+			observeRateLimit(regUrl, resp.Header())
+			return nil
+		})
+}
@@ -1,13 +1,22 @@
 package registry
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"log"
+	"maps"
+	"net/url"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/mlofjard/contrack/cache"
 	. "github.com/mlofjard/contrack/types"
 
-	"github.com/go-resty/resty/v2"
 	p "github.com/schollz/progressbar/v3"
 )
 
@@ -18,51 +27,252 @@ var DomainRegistryMap = map[string]Registry{
 	"ghcr.io":   Ghcr{"https://ghcr.io/v2"},
 }
 
+// Normalizes a registry domain like `Registry.lan:5000/` for matching image
+// references against configured registries. Host names are case insensitive.
+func NormalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimRight(strings.TrimSpace(domain), "/"))
+}
+
 type tagResponse struct {
 	Name string
 	Tags []string
 }
 
-func TagFetcherFunc(regUrl string, authType AuthType, authToken string, image string, tags *TagList, last string) int {
-	status := 200
-	client := resty.New().
-		SetQueryParam("n", "1000").
-		SetQueryParam("last", last)
+// Returns the rel="next" target of an RFC 5988 Link header, resolved
+// against the url of the request it was returned for
+func parseNextLink(header string, requestUrl *url.URL) (string, bool) {
+	for _, link := range strings.Split(header, ",") {
+		target, params, found := strings.Cut(link, ";")
+		if !found {
+			continue
+		}
+		target = strings.TrimSpace(target)
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if !strings.EqualFold(key, "rel") || !slices.Contains(strings.Fields(strings.Trim(value, `"`)), "next") {
+				continue
+			}
+			next, err := url.Parse(strings.Trim(target, "<>"))
+			if err != nil {
+				return "", false
+			}
+			return requestUrl.ResolveReference(next).String(), true
+		}
+	}
+	return "", false
+}
+
+// Fetches all pages of the tag list, following the Link header the
+// registry returns until there is no next page or MaxPages is reached
+func TagFetcherFunc(regUrl string, tlsConfig *tls.Config, authType AuthType, authToken string, image string, tags *TagList) int {
+	client := newRetryClient(regUrl, tlsConfig)
 
 	if authType != AuthTypes.None {
 		client.SetAuthScheme(authType.Scheme)
 		client.SetAuthToken(authToken)
 	}
 
-	url := fmt.Sprintf("%s/%s/tags/list", regUrl, image)
-	tagResponse := &tagResponse{}
-	resp, err := client.R().
-		SetResult(tagResponse).
-		Get(url)
+	// Only single page lists keep an ETag, a changed list is fetched in full
+	request := client.R()
+	if tags.PageSize > 0 {
+		request.SetQueryParam("n", strconv.Itoa(tags.PageSize))
+	}
+	if tags.ETag != "" {
+		request.SetHeader("if-none-match", tags.ETag)
+	}
 
-	if err != nil {
-		tags.Tags = []string{}
-		return -1
+	next := fmt.Sprintf("%s/%s/tags/list", regUrl, image)
+	fetched := make(map[string]bool)
+	for page := 1; next != ""; page++ {
+		// A next link back to a fetched page would loop forever
+		if fetched[next] {
+			break
+		}
+		if tags.MaxPages > 0 && page > tags.MaxPages {
+			tags.Truncated = true
+			break
+		}
+
+		resp, err := request.Get(next)
+		// Giving up on a long Retry-After still leaves the response status
+		if err != nil && (resp == nil || resp.StatusCode() == 0) {
+			tags.Tags = []string{}
+			return -1
+		}
+		if page == 1 {
+			if resp.StatusCode() == 304 {
+				tags.NotModified = true
+				return 200
+			}
+			tags.ETag = resp.Header().Get("etag")
+		}
+		if resp.StatusCode() != 200 {
+			tags.Tags = []string{}
+			return resp.StatusCode()
+		}
+
+		// Not all registries send a JSON content type, so decode explicitly
+		tagResponse := &tagResponse{}
+		if err := json.Unmarshal(resp.Body(), tagResponse); err != nil {
+			tags.Tags = []string{}
+			return -1
+		}
+		tags.Tags = slices.Concat(tags.Tags, tagResponse.Tags)
+
+		// The next url carries its own query, like n and last
+		fetched[next] = true
+		fetched[resp.RawResponse.Request.URL.String()] = true
+		next, _ = parseNextLink(resp.Header().Get("link"), resp.RawResponse.Request.URL)
+		// The first page being unchanged says nothing about the pages after it
+		if next != "" {
+			tags.ETag = ""
+		}
+		request = client.R()
+	}
+	return 200
+}
+
+// Manifest media types accepted when fetching digests, lists first so the
+// digest matches the one recorded when pulling a multi-platform image
+var manifestAcceptHeaders = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+func DigestFetcherFunc(regUrl string, tlsConfig *tls.Config, authType AuthType, authToken string, image string, tag string) (string, int) {
+	client := newRetryClient(regUrl, tlsConfig).
+		SetHeader("accept", strings.Join(manifestAcceptHeaders, ", "))
+
+	if authType != AuthTypes.None {
+		client.SetAuthScheme(authType.Scheme)
+		client.SetAuthToken(authToken)
+	}
+
+	url := fmt.Sprintf("%s/%s/manifests/%s", regUrl, image, tag)
+	resp, err := client.R().Head(url)
+	if err != nil && (resp == nil || resp.StatusCode() == 0) {
+		return "", -1
 	}
 	if resp.StatusCode() != 200 {
-		tags.Tags = []string{}
-		return resp.StatusCode()
+		return "", resp.StatusCode()
+	}
+	return resp.Header().Get("docker-content-digest"), 200
+}
+
+// Fetches tags through the cache. Fresh entries are used as is, stale entries
+// are revalidated with their ETag.
+func fetchCachedTags(config Config, tagCache *cache.Cache, key string, fetch func(*TagList) int) (*TagList, int) {
+	entry, cached, err := tagCache.Get(key)
+	if err != nil {
+		log.Printf("Ignoring tag cache for %s: %v", key, err)
 	}
-	var lastTag string
-	newList := make([]string, len(tagResponse.Tags))
-	for i, t := range tagResponse.Tags {
-		newList[i] = t
-		lastTag = t
+	if cached && tagCache.Fresh(entry, time.Now()) {
+		if config.Debug {
+			fmt.Printf("Cached tags for %s, Tags: %d\n", key, len(entry.Tags))
+		}
+		return &TagList{Tags: entry.Tags, ETag: entry.ETag}, 200
 	}
-	tags.Tags = slices.Concat(tags.Tags, newList)
 
-	if resp.Header().Get("link") != "" {
-		status = TagFetcherFunc(regUrl, authType, authToken, image, tags, lastTag)
+	remoteTags := &TagList{Tags: []string{}}
+	if cached {
+		remoteTags.ETag = entry.ETag
+		remoteTags.Source = entry.Source
+	}
+	status := fetch(remoteTags)
+	if status != 200 {
+		return remoteTags, status
 	}
-	return status
+	if remoteTags.NotModified {
+		if config.Debug {
+			fmt.Printf("Cached tags for %s not modified, Tags: %d\n", key, len(entry.Tags))
+		}
+		remoteTags.Tags = entry.Tags
+	}
+
+	// A truncated list would be revalidated as complete by later runs
+	if remoteTags.Truncated {
+		return remoteTags, status
+	}
+	err = tagCache.Put(key, cache.Entry{Tags: remoteTags.Tags, ETag: remoteTags.ETag, Source: remoteTags.Source, FetchedAt: time.Now()})
+	if err != nil {
+		log.Printf("Error caching tags for %s: %v", key, err)
+	}
+	return remoteTags, status
 }
 
-func FetchTags(config Config, imageTagMap ImageTagMap, domainGroupedRepoMap DomainGroupedRepoMap, domainConfiguredRegistryMap DomainConfiguredRegistryMap, imageCount int, fetcherFn RegistryTagFetcherFn) {
+// Gets the auth to use for all paths of the grouped repository, registries
+// that return no token are used with anonymous access
+func authenticate(configuredRegistry ConfiguredRegistry, groupedRepo GroupedRepository) (AuthType, string, error) {
+	token, authType, err := configuredRegistry.Registry.GetAuth(groupedRepo, configuredRegistry.AuthType, configuredRegistry.AuthToken, configuredRegistry.TLS)
+	if err != nil {
+		return AuthTypes.None, "", err
+	}
+	if token == "" {
+		return AuthTypes.None, "", nil
+	}
+	return authType, token, nil
+}
+
+// Registry or mirror that tags are fetched from
+type tagSource struct {
+	url       string
+	tlsConfig *tls.Config
+	authType  AuthType
+	authToken string
+	stopOnce  *sync.Once
+}
+
+// Authenticates with the mirrors of the registry and the registry itself, in
+// the order tags are fetched from them. Only a failing registry without any
+// mirror left is an error.
+func tagSources(configuredRegistry ConfiguredRegistry, groupedRepo GroupedRepository) ([]tagSource, error) {
+	sources := []tagSource{}
+	for _, mirror := range configuredRegistry.Mirrors {
+		authType, authToken, err := authenticate(mirror, groupedRepo)
+		if err != nil {
+			log.Printf("Skipping mirror %s: %v", mirror.Registry.GetUrl(), err)
+			continue
+		}
+		sources = append(sources, tagSource{url: mirror.Registry.GetUrl(), tlsConfig: mirror.TLS, authType: authType, authToken: authToken, stopOnce: &sync.Once{}})
+	}
+
+	authType, authToken, err := authenticate(configuredRegistry, groupedRepo)
+	if err != nil {
+		if len(sources) == 0 {
+			return nil, err
+		}
+		log.Printf("Authentication with %s failed, using mirrors only: %v", configuredRegistry.Domain, err)
+		return sources, nil
+	}
+	return append(sources, tagSource{url: configuredRegistry.Registry.GetUrl(), tlsConfig: configuredRegistry.TLS, authType: authType, authToken: authToken, stopOnce: &sync.Once{}}), nil
+}
+
+// Classifies the status returned by a tag fetcher, nil when it succeeded
+func statusError(status int) error {
+	switch status {
+	case 200:
+		return nil
+	case -1:
+		return NewError(ErrorKinds.Network, "Registry could not be reached", nil)
+	case 401, 403:
+		return NewError(ErrorKinds.Auth, "Registry authentication error", nil)
+	case 404:
+		return NewError(ErrorKinds.NotFound, "Repository not found", nil)
+	case 429:
+		return NewError(ErrorKinds.RateLimited, "Registry rate limit reached", nil)
+	}
+	if status >= 500 {
+		return NewError(ErrorKinds.Registry, "Registry server error", nil)
+	}
+	return NewError(ErrorKinds.Registry, fmt.Sprintf("Registry error %d", status), nil)
+}
+
+func FetchTags(config Config, imageTagMap ImageTagMap, domainGroupedRepoMap DomainGroupedRepoMap, domainConfiguredRegistryMap DomainConfiguredRegistryMap, imageCount int, fetcherFn RegistryTagFetcherFn, digestFetcherFn RegistryDigestFetcherFn) {
 	bar := p.NewOptions(imageCount,
 		p.OptionSetWriter(os.Stdout),
 		p.OptionClearOnFinish(),
@@ -73,40 +283,151 @@ func FetchTags(config Config, imageTagMap ImageTagMap, domainGroupedRepoMap Doma
 		p.OptionShowCount(),
 	)
 
-	for domain, groupedRepo := range domainGroupedRepoMap {
+	tagCache := cache.New(config.CacheDir, config.CacheTtl, config.Refresh)
+
+	// Limits the total number of concurrent tag fetches across all registries
+	globalSlots := make(chan struct{}, max(config.Concurrency, 1))
+	imageTagMutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	// Registries are started in sorted order, their auth and fetches then run concurrently
+	for _, domain := range slices.Sorted(maps.Keys(domainGroupedRepoMap)) {
+		groupedRepo := domainGroupedRepoMap[domain]
 		if config.Debug {
 			fmt.Printf("Domain: %s, Images: %d\n", domain, len(groupedRepo.Paths))
 		}
 
-		authType := AuthTypes.None
-		authToken := ""
-		if configuredRegistry, ok := domainConfiguredRegistryMap[groupedRepo.Domain]; ok {
+		configuredRegistry, ok := domainConfiguredRegistryMap[groupedRepo.Domain]
+		if !ok {
+			if config.Debug {
+				fmt.Printf("Registry NOT found: %s\n", groupedRepo.Domain)
+			}
+			continue
+		}
 
-			reg := configuredRegistry.Registry
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
 			if config.Debug {
-				fmt.Printf("Registry found with url: %s\n", reg.GetUrl())
+				fmt.Printf("Registry found with url: %s\n", configuredRegistry.Registry.GetUrl())
 			}
 
-			regUrl := reg.GetUrl()
-			token, regAuthType := reg.GetAuth(groupedRepo, configuredRegistry.AuthType, configuredRegistry.AuthToken)
-			if token != "" {
-				authType = regAuthType
-				authToken = token
-			}
+			// Authenticate once per registry and mirror, only when a path isn't
+			// answered by the cache, so fresh entries don't need the registry
+			authenticateOnce := sync.OnceValues(func() ([]tagSource, error) {
+				sources, err := tagSources(configuredRegistry, groupedRepo)
+				if err != nil {
+					// Every repository of the registry that needs it fails the same way
+					if config.Debug {
+						fmt.Printf("Authentication with %s failed: %v\n", domain, err)
+					}
+					return nil, err
+				}
+				// Rate limits are reported again by the first responses of this run
+				for _, source := range sources {
+					resetRateLimit(source.url)
+				}
+				return sources, nil
+			})
 
+			// Limits the number of concurrent tag fetches against this registry
+			registrySlots := make(chan struct{}, max(configuredRegistry.Concurrency, 1))
 			for _, path := range groupedRepo.Paths {
-				// Fetch all tags
-				remoteTags := &TagList{Tags: []string{}}
-				status := fetcherFn(regUrl, authType, authToken, path, remoteTags, "")
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					registrySlots <- struct{}{}
+					globalSlots <- struct{}{}
+					defer func() {
+						<-globalSlots
+						<-registrySlots
+					}()
+
+					// Fetch all tags, from the first source that has them. Results
+					// are kept under the registry domain, whichever source answered.
+					uniqueIdentifier := fmt.Sprintf("%s/%s", domain, path)
+					started := time.Now()
+					var fetchErr error
+					remoteTags, status := fetchCachedTags(config, tagCache, uniqueIdentifier, func(tags *TagList) int {
+						sources, err := authenticateOnce()
+						if err != nil {
+							fetchErr = err
+							return 0
+						}
+						status := 0
+						for _, source := range sources {
+							// Stop asking a source that is out of quota, instead of failing every request
+							if limit, ok := currentRateLimit(source.url); ok && limit.Remaining <= config.MinRateLimit {
+								source.stopOnce.Do(func() {
+									log.Printf("Stopping tag fetches from %s, rate limit remaining %d", source.url, limit.Remaining)
+								})
+								status = 0
+								fetchErr = NewError(ErrorKinds.RateLimited, fmt.Sprintf("Skipped, registry rate limit remaining %d", limit.Remaining), nil)
+								continue
+							}
+
+							// Pages are appended to the list, so every source starts from scratch. Only
+							// the source an ETag came from can tell whether the list is unchanged.
+							attempt := &TagList{Tags: []string{}, PageSize: config.PageSize, MaxPages: config.MaxPages, Source: source.url}
+							if tags.Source == source.url {
+								attempt.ETag = tags.ETag
+							}
+							status = fetcherFn(source.url, source.tlsConfig, source.authType, source.authToken, path, attempt)
+							fetchErr = statusError(status)
+							if status == 200 {
+								*tags = *attempt
+								break
+							}
+							if config.Debug {
+								fmt.Printf("Tags for %s from %s, Status: %d\n", uniqueIdentifier, source.url, status)
+							}
+						}
+						return status
+					})
+					if remoteTags.Truncated {
+						log.Printf("Tags for %s truncated after %d pages", uniqueIdentifier, config.MaxPages)
+					}
+
+					// Fetch digests for floating tags
+					digests := make(map[string]string)
+					for _, tag := range groupedRepo.DigestTags[path] {
+						sources, err := authenticateOnce()
+						if err != nil {
+							break
+						}
+						for _, source := range sources {
+							digest, digestStatus := digestFetcherFn(source.url, source.tlsConfig, source.authType, source.authToken, path, tag)
+							if config.Debug {
+								fmt.Printf("Digest for %s/%s:%s, Status: %d, Digest: %s\n", domain, path, tag, digestStatus, digest)
+							}
+							if digestStatus == 200 {
+								digests[tag] = digest
+								break
+							}
+						}
+					}
 
-				uniqueIdentifier := fmt.Sprintf("%s/%s", domain, path)
-				imageTagMap[uniqueIdentifier] = ImageTags{Status: status, Tags: remoteTags.Tags}
-				bar.Add(1)
+					imageTagMutex.Lock()
+					imageTagMap[uniqueIdentifier] = ImageTags{Status: status, Err: fetchErr, Tags: remoteTags.Tags, Digests: digests, Duration: time.Since(started)}
+					imageTagMutex.Unlock()
+					bar.Add(1)
+				}()
 			}
-		} else {
-			if config.Debug {
-				fmt.Printf("Registry NOT found: %s\n", groupedRepo.Domain)
+		}()
+	}
+	wg.Wait()
+
+	if config.Debug {
+		for _, domain := range slices.Sorted(maps.Keys(domainGroupedRepoMap)) {
+			configuredRegistry, ok := domainConfiguredRegistryMap[domain]
+			if !ok {
+				continue
+			}
+			for _, reg := range slices.Concat(configuredRegistry.Mirrors, []ConfiguredRegistry{configuredRegistry}) {
+				if limit, ok := currentRateLimit(reg.Registry.GetUrl()); ok {
+					fmt.Printf("Rate limit for %s: %d of %d remaining\n", reg.Registry.GetUrl(), limit.Remaining, limit.Limit)
+				}
 			}
 		}
 	}
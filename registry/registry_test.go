@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mlofjard/contrack/cache"
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Registry whose authentication always fails, counting the attempts
+type unreachableRegistry struct {
+	authCalls *atomic.Int32
+}
+
+func (r unreachableRegistry) GetUrl() string {
+	return "https://registry.invalid/v2"
+}
+
+func (r unreachableRegistry) GetAuth(GroupedRepository, AuthType, string, *tls.Config) (string, AuthType, error) {
+	r.authCalls.Add(1)
+	return "", AuthTypes.None, errors.New("registry unreachable")
+}
+
+func TestFetchTagsFromFreshCache(t *testing.T) {
+	config := Config{CacheDir: t.TempDir(), CacheTtl: time.Hour, NoProgress: true, Concurrency: 1}
+	tagCache := cache.New(config.CacheDir, config.CacheTtl, false)
+	if err := tagCache.Put("registry.lan/cached", cache.Entry{Tags: []string{"1.0.0", "1.1.0"}, FetchedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	authCalls := &atomic.Int32{}
+	domainGroupedRepoMap := DomainGroupedRepoMap{
+		"registry.lan": GroupedRepository{Domain: "registry.lan", Paths: []string{"cached"}},
+	}
+	domainConfiguredRegistryMap := DomainConfiguredRegistryMap{
+		"registry.lan": ConfiguredRegistry{Domain: "registry.lan", Registry: unreachableRegistry{authCalls: authCalls}, Concurrency: 1},
+	}
+	fetcherFn := func(string, *tls.Config, AuthType, string, string, *TagList) int {
+		t.Error("fetcher called for a fresh cache entry")
+		return -1
+	}
+
+	imageTagMap := make(ImageTagMap)
+	FetchTags(config, imageTagMap, domainGroupedRepoMap, domainConfiguredRegistryMap, 1, fetcherFn, nil)
+
+	imageTags := imageTagMap["registry.lan/cached"]
+	if imageTags.Err != nil || !slices.Equal(imageTags.Tags, []string{"1.0.0", "1.1.0"}) {
+		t.Errorf("got tags %v and error %v, want the cached tags", imageTags.Tags, imageTags.Err)
+	}
+	if calls := authCalls.Load(); calls != 0 {
+		t.Errorf("authenticated %d times, want 0", calls)
+	}
+
+	// A path that isn't cached still needs the registry, and fails with it
+	domainGroupedRepoMap["registry.lan"] = GroupedRepository{Domain: "registry.lan", Paths: []string{"cached", "uncached"}}
+	imageTagMap = make(ImageTagMap)
+	FetchTags(config, imageTagMap, domainGroupedRepoMap, domainConfiguredRegistryMap, 2, fetcherFn, nil)
+
+	if imageTags := imageTagMap["registry.lan/cached"]; imageTags.Err != nil {
+		t.Errorf("cached path got error %v", imageTags.Err)
+	}
+	if imageTags := imageTagMap["registry.lan/uncached"]; imageTags.Err == nil {
+		t.Error("uncached path got no error")
+	}
+	if calls := authCalls.Load(); calls != 1 {
+		t.Errorf("authenticated %d times, want 1", calls)
+	}
+}
+
+// Starts a registry serving the tags of app in pages, every page has its own ETag
+func newPagedRegistry(t *testing.T, pages *[][]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		etag := fmt.Sprintf(`"page-%d-%d"`, page, len((*pages)[page]))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if page+1 < len(*pages) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/app/tags/list?page=%d>; rel="next"`, page+1))
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `{"name":"app","tags":["%s"]}`, strings.Join((*pages)[page], `","`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCachedTagsRevalidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages [][]string
+		// Tags added to the last page between the runs
+		added string
+		want  []string
+	}{
+		{name: "single page", pages: [][]string{{"a", "b"}}, added: "c", want: []string{"a", "b", "c"}},
+		{name: "unchanged single page", pages: [][]string{{"a", "b"}}, want: []string{"a", "b"}},
+		{name: "later page changed", pages: [][]string{{"a"}, {"b"}}, added: "c", want: []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newPagedRegistry(t, &test.pages)
+			config := Config{}
+			// Every entry is stale, so each run revalidates
+			tagCache := cache.New(t.TempDir(), 0, false)
+			fetch := func(tags *TagList) int {
+				return TagFetcherFunc(server.URL+"/v2", nil, AuthTypes.None, "", "app", tags)
+			}
+
+			if _, status := fetchCachedTags(config, tagCache, "registry.lan/app", fetch); status != 200 {
+				t.Fatalf("first run status %d", status)
+			}
+			if test.added != "" {
+				last := len(test.pages) - 1
+				test.pages[last] = append(test.pages[last], test.added)
+			}
+			tags, status := fetchCachedTags(config, tagCache, "registry.lan/app", fetch)
+			if status != 200 {
+				t.Fatalf("second run status %d", status)
+			}
+			if !slices.Equal(tags.Tags, test.want) {
+				t.Errorf("got tags %v, want %v", tags.Tags, test.want)
+			}
+		})
+	}
+}
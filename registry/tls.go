@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"crypto/tls"
+	"net/url"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Creates a client for requests to a registry, with its TLS config if it has one
+func newClient(tlsConfig *tls.Config) *resty.Client {
+	client := resty.New()
+	if tlsConfig != nil {
+		client.SetTLSClientConfig(tlsConfig)
+	}
+	return client
+}
+
+// Returns the TLS config for the token realm of a registry. Only a realm on
+// the registry host itself gets the registry TLS config, so a server name,
+// skipped verification or client certificate never applies to another host.
+func realmTLS(regUrl string, realm string, tlsConfig *tls.Config) *tls.Config {
+	registryUrl, err := url.Parse(regUrl)
+	if err != nil {
+		return nil
+	}
+	realmUrl, err := url.Parse(realm)
+	if err != nil || !strings.EqualFold(realmUrl.Host, registryUrl.Host) {
+		return nil
+	}
+	return tlsConfig
+}
@@ -0,0 +1,25 @@
+package registry
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestRealmTLS(t *testing.T) {
+	tlsConfig := &tls.Config{ServerName: "registry.lan", InsecureSkipVerify: true}
+	tests := []struct {
+		realm string
+		want  *tls.Config
+	}{
+		{realm: "https://registry.lan:5000/token", want: tlsConfig},
+		{realm: "https://REGISTRY.lan:5000/auth/token?x=1", want: tlsConfig},
+		{realm: "https://registry.lan/token", want: nil},
+		{realm: "https://auth.example.com/token", want: nil},
+		{realm: "://invalid", want: nil},
+	}
+	for _, test := range tests {
+		if got := realmTLS("https://registry.lan:5000/v2", test.realm, tlsConfig); got != test.want {
+			t.Errorf("realmTLS(%q) = %v, want %v", test.realm, got, test.want)
+		}
+	}
+}
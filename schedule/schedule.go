@@ -0,0 +1,188 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schedule interface {
+	// Returns the first run time after t
+	Next(t time.Time) time.Time
+}
+
+type Interval struct {
+	Duration time.Duration
+}
+
+func (s Interval) Next(t time.Time) time.Time {
+	return t.Add(s.Duration)
+}
+
+// A standard five field cron expression: minute hour day-of-month month day-of-week
+type Cron struct {
+	minutes     []bool
+	hours       []bool
+	daysOfMonth []bool
+	months      []bool
+	daysOfWeek  []bool
+	// Day of month and day of week match when either does if both are restricted
+	domRestricted bool
+	dowRestricted bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parses one cron field, supporting *, lists, ranges and steps
+func parseField(field string, low int, high int) ([]bool, error) {
+	values := make([]bool, high+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := low, high
+		if rangePart != "*" {
+			startStr, endStr, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(startStr); err != nil {
+				return nil, fmt.Errorf("invalid value in %q", part)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endStr); err != nil {
+					return nil, fmt.Errorf("invalid range in %q", part)
+				}
+			} else if hasStep {
+				end = high
+			}
+		}
+		if start < low || end > high || start > end {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, low, high)
+		}
+
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func ParseCron(expression string) (Cron, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expression)]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return Cron{}, fmt.Errorf("cron expression %q must have 5 fields", expression)
+	}
+
+	var err error
+	cron := Cron{}
+	if cron.minutes, err = parseField(fields[0], 0, 59); err != nil {
+		return Cron{}, fmt.Errorf("minute: %w", err)
+	}
+	if cron.hours, err = parseField(fields[1], 0, 23); err != nil {
+		return Cron{}, fmt.Errorf("hour: %w", err)
+	}
+	if cron.daysOfMonth, err = parseField(fields[2], 1, 31); err != nil {
+		return Cron{}, fmt.Errorf("day of month: %w", err)
+	}
+	if cron.months, err = parseField(fields[3], 1, 12); err != nil {
+		return Cron{}, fmt.Errorf("month: %w", err)
+	}
+	if cron.daysOfWeek, err = parseField(fields[4], 0, 7); err != nil {
+		return Cron{}, fmt.Errorf("day of week: %w", err)
+	}
+	// Both 0 and 7 are Sunday
+	if cron.daysOfWeek[7] {
+		cron.daysOfWeek[0] = true
+	}
+	// Fields like */1 select every day just like *
+	cron.domRestricted = slices.Contains(cron.daysOfMonth[1:], false)
+	cron.dowRestricted = slices.Contains(cron.daysOfWeek[:7], false)
+
+	if !cron.possible() {
+		return Cron{}, fmt.Errorf("cron expression %q never matches", expression)
+	}
+	return cron, nil
+}
+
+// Days in each month, with February in a leap year
+var monthDays = []int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// Returns whether a selected day of month exists in a selected month. Only
+// matters when days are selected by day of month alone, every day of the
+// week occurs in every month.
+func (c Cron) possible() bool {
+	if !c.domRestricted || c.dowRestricted {
+		return true
+	}
+	for month := 1; month <= 12; month++ {
+		if c.months[month] && slices.Contains(c.daysOfMonth[1:monthDays[month]+1], true) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Cron) matchesDay(t time.Time) bool {
+	dom := c.daysOfMonth[t.Day()]
+	dow := c.daysOfWeek[int(t.Weekday())]
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c Cron) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	// Any valid expression matches within a few years
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		if !c.months[int(next.Month())] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.hours[next.Hour()] {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.minutes[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return limit
+}
+
+// Creates a schedule from a cron expression if set, otherwise from the interval
+func New(interval time.Duration, cron string) (Schedule, error) {
+	if cron != "" {
+		return ParseCron(cron)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive, got %s", interval)
+	}
+	return Interval{Duration: interval}, nil
+}
@@ -0,0 +1,104 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Thursday
+	from := time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expression string
+		want       time.Time
+	}{
+		{expression: "@hourly", want: time.Date(2026, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{expression: "@daily", want: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{expression: "@midnight", want: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{expression: "@weekly", want: time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{expression: "@monthly", want: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{expression: "@yearly", want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expression: " @annually ", want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expression: "* * * * *", want: time.Date(2026, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{expression: "*/15 * * * *", want: time.Date(2026, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{expression: "5,35 * * * *", want: time.Date(2026, 1, 15, 10, 35, 0, 0, time.UTC)},
+		{expression: "10-20 * * * *", want: time.Date(2026, 1, 15, 11, 10, 0, 0, time.UTC)},
+		{expression: "0 9-17/4 * * *", want: time.Date(2026, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{expression: "0 20/2 * * *", want: time.Date(2026, 1, 15, 20, 0, 0, 0, time.UTC)},
+		{expression: "0 0 31 * *", want: time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 * * 1", want: time.Date(2026, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 * * 7", want: time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 * * 1-5", want: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week when both are restricted
+		{expression: "0 0 1,20 * 1", want: time.Date(2026, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 16 * 1", want: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 31 2 1", want: time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC)},
+		// A step over every day isn't a restriction
+		{expression: "0 0 */1 * 1", want: time.Date(2026, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 15 * */1", want: time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		cron, err := ParseCron(test.expression)
+		if err != nil {
+			t.Errorf("ParseCron(%q) returned error: %v", test.expression, err)
+			continue
+		}
+		if got := cron.Next(from); !got.Equal(test.want) {
+			t.Errorf("ParseCron(%q).Next() = %s, want %s", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@often",
+		"60 * * * *",
+		"0 24 * * *",
+		"0 0 0 * *",
+		"0 0 32 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"a * * * *",
+		"1-a * * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"0 0 31 2 *",
+		"0 0 30,31 2 *",
+		"0 0 31 4,6,9,11 *",
+	}
+	for _, expression := range tests {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("ParseCron(%q) returned no error", expression)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	from := time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	schedule, err := New(time.Hour, "")
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if got := schedule.Next(from); !got.Equal(from.Add(time.Hour)) {
+		t.Errorf("Interval.Next() = %s, want %s", got, from.Add(time.Hour))
+	}
+
+	// The cron expression overrides the interval
+	schedule, err = New(time.Hour, "@daily")
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if got, want := schedule.Next(from), time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Cron.Next() = %s, want %s", got, want)
+	}
+
+	if _, err := New(0, ""); err == nil {
+		t.Error("New with a zero interval returned no error")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/mlofjard/contrack/api"
+	"github.com/mlofjard/contrack/state"
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Runs the pipeline on schedule like watch, and serves the latest results
+// over HTTP until the context is done
+func serve(ctx context.Context, config Config, domainConfiguredRegistryMap DomainConfiguredRegistryMap, fns pipelineFns, updateState *state.State) {
+	apiServer := api.NewServer()
+	server := &http.Server{Addr: config.Listen, Handler: apiServer.Handler()}
+
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		log.Fatalf("Error serving API: %v", err)
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error serving API: %v", err)
+		}
+	}()
+	log.Printf("Serving API on %s", listener.Addr())
+
+	watch(ctx, config, domainConfiguredRegistryMap, fns, updateState, apiServer.Checks(), func(run pipelineRun) {
+		apiServer.Update(api.Snapshot{Results: run.results, ImageTagMap: run.imageTagMap, Started: run.started, Duration: run.duration})
+	})
+	server.Close()
+}
@@ -0,0 +1,125 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Version of the state file format, bump on incompatible changes
+const fileVersion = 1
+
+type Entry struct {
+	Repository string    `json:"repository"`
+	Host       string    `json:"host,omitempty"`
+	Container  string    `json:"container"`
+	Update     string    `json:"update"`
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+// Pending updates by repository and container. A state without a path is
+// only kept in memory.
+type State struct {
+	path    string
+	Version int              `json:"version"`
+	Entries map[string]Entry `json:"entries"`
+}
+
+// Containers without a configured host keep the key they had before hosts existed
+func entryKey(repository string, host string, container string) string {
+	if host == "" {
+		return fmt.Sprintf("%s|%s", repository, container)
+	}
+	return fmt.Sprintf("%s|%s|%s", repository, host, container)
+}
+
+// Loads the state file, a missing file gives an empty state
+func Load(path string) (*State, error) {
+	state := &State{path: path, Version: fileVersion, Entries: make(map[string]Entry)}
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	if state.Version != fileVersion {
+		return nil, fmt.Errorf("state file %s has unsupported version %d", path, state.Version)
+	}
+	if state.Entries == nil {
+		state.Entries = make(map[string]Entry)
+	}
+	return state, nil
+}
+
+// Records the updates in the results, marks updates not seen in a previous
+// run as new and sets when each update was first detected. An entry is only
+// removed once a successful check finds no update, entries of containers that
+// failed or were not checked in this run are kept.
+func (s *State) Apply(results []ContainerResult, now time.Time) {
+	entries := maps.Clone(s.Entries)
+	for idx := range results {
+		result := &results[idx]
+		key := entryKey(result.Repository, result.Host, result.Container)
+		if result.Status == "ERR" {
+			continue
+		}
+		if result.Update == "" {
+			delete(entries, key)
+			continue
+		}
+
+		entry, found := entries[key]
+		if !found || entry.Update != result.Update {
+			entry = Entry{
+				Repository: result.Repository,
+				Host:       result.Host,
+				Container:  result.Container,
+				Update:     result.Update,
+				FirstSeen:  now,
+			}
+			result.New = true
+		}
+		entry.LastSeen = now
+		entries[key] = entry
+
+		firstSeen := entry.FirstSeen
+		result.UpdateSince = &firstSeen
+	}
+	s.Entries = entries
+}
+
+// Writes the state file atomically, does nothing for in memory state
+func (s *State) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,54 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Creates a client TLS config from configured certificate paths. An empty
+// config returns nil, leaving the defaults of the client in place.
+func Client(config TLSConfig) (*tls.Config, error) {
+	if config == (TLSConfig{}) {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CA != "" {
+		data, err := os.ReadFile(config.CA)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		// The bundle is trusted in addition to the system roots, token
+		// endpoints of a registry often use publicly trusted certificates
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.Cert != "" || config.Key != "" {
+		if config.Cert == "" || config.Key == "" {
+			return nil, fmt.Errorf("client certificate needs both cert and key")
+		}
+		cert, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -1,15 +1,44 @@
 package types
 
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+)
+
 type CommandFlags struct {
-	ConfigPathPtr *string
-	DebugPtr      *bool
-	MockPtr       *string
-	ColumnsPtr    *string
-	HostPtr       *string
-	IncludeAllPtr *bool
-	NoProgressPtr *bool
-	VersionPtr    *bool
-	HelpPtr       *bool
+	ConfigPathPtr          *string
+	DebugPtr               *bool
+	MockPtr                *string
+	ColumnsPtr             *string
+	HostPtr                *string
+	IncludeAllPtr          *bool
+	NoProgressPtr          *bool
+	ConcurrencyPtr         *int
+	RegistryConcurrencyPtr *int
+	OutputPtr              *string
+	FailOnPtr              *string
+	DiscoveryPtr           *string
+	DiscoveryPathsPtr      *[]string
+	IntervalPtr            *time.Duration
+	CronPtr                *string
+	CommandPtr             *string
+	StateFilePtr           *string
+	OnlyNewPtr             *bool
+	MetricsListenPtr       *string
+	MetricsFilePtr         *string
+	ListenPtr              *string
+	CacheDirPtr            *string
+	CacheTtlPtr            *time.Duration
+	RefreshPtr             *bool
+	MinRateLimitPtr        *int
+	PageSizePtr            *int
+	MaxPagesPtr            *int
+	VersionPtr             *bool
+	HelpPtr                *bool
 }
 
 type AuthType struct {
@@ -24,67 +53,215 @@ type authTypes struct {
 
 var AuthTypes = authTypes{None: AuthType{0, "None"}, Basic: AuthType{1, "Basic"}, Bearer: AuthType{2, "Bearer"}}
 
+// Kinds of errors passed through the pipeline
+type ErrorKind string
+
+type errorKinds struct {
+	Auth        ErrorKind
+	Network     ErrorKind
+	NotFound    ErrorKind
+	RateLimited ErrorKind
+	Parse       ErrorKind
+	Registry    ErrorKind
+}
+
+var ErrorKinds = errorKinds{Auth: "auth", Network: "network", NotFound: "not_found", RateLimited: "rate_limited", Parse: "parse", Registry: "registry"}
+
+// Error with a kind, so callers can decide how to handle it without matching messages
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func NewError(kind ErrorKind, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Returns the kind of the first Error in the chain, empty if there is none
+func ErrorKindOf(err error) ErrorKind {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed.Kind
+	}
+	return ""
+}
+
+// Process exit codes
+const (
+	ExitOk      = 0
+	ExitFatal   = 1
+	ExitUpdates = 2
+	ExitErrors  = 3
+)
+
 type Config struct {
-	Debug      bool
-	IncludeAll bool
-	NoProgress bool
-	Host       string
-	Columns    []string
+	Debug               bool
+	IncludeAll          bool
+	NoProgress          bool
+	Host                string
+	Hosts               []DockerHost
+	Columns             []string
+	Concurrency         int
+	RegistryConcurrency int
+	Output              string
+	FailOn              string
+	Discovery           string
+	DiscoveryPaths      []string
+	Command             string
+	Interval            time.Duration
+	Cron                string
+	Notifiers           []NotifierConfig
+	StateFile           string
+	OnlyNew             bool
+	MetricsListen       string
+	MetricsFile         string
+	Listen              string
+	CacheDir            string
+	CacheTtl            time.Duration
+	Refresh             bool
+	MinRateLimit        int
+	PageSize            int
+	MaxPages            int
+}
+
+type NotifierConfig struct {
+	Name     string
+	Url      string
+	Method   string
+	Headers  map[string]string
+	Format   string
+	Template string
+	// Parsed from Template or Format when the config is loaded, nil sends JSON
+	Body *template.Template
 }
 type DomainConfiguredRegistryMap = map[string]ConfiguredRegistry
 
+// Certificates and verification options used to connect to a host or registry
+type TLSConfig struct {
+	CA                 string
+	Cert               string
+	Key                string
+	InsecureSkipVerify bool
+	ServerName         string
+}
+
+// Docker or Podman daemon to discover containers on
+type DockerHost struct {
+	Name     string
+	Endpoint string
+	TLS      TLSConfig
+}
+
 type ConfiguredRegistry struct {
-	AuthType  AuthType
-	AuthToken string
-	Domain    string
-	Name      string
-	Registry  Registry
+	AuthType    AuthType
+	AuthToken   string
+	Domain      string
+	Name        string
+	Registry    Registry
+	Concurrency int
+	TLS         *tls.Config
+	// Tried in order before the registry itself, anonymously and with their own TLS config
+	Mirrors []ConfiguredRegistry
 }
 
 type Container struct {
-	Name   string
-	Image  string
-	Labels map[string]string
+	Name    string
+	Image   string
+	Labels  map[string]string
+	Digests []string
+	// Platform of the host running the container, empty if unknown
+	Platform string
+	// Name of the configured host running the container
+	Host string
+	// Set instead of the image for a host whose containers could not be listed
+	Err error
 }
 
 type TrackedContainer struct {
 	Name    string
+	Host    string
 	Tracked bool
 	Image   ContainerImage
 	Labels  ContainerLabels
+	Err     error
 }
 
 type ContainerImage struct {
 	Path   string
 	Domain string
 	Tag    string
+	Digest string
 }
 
 type ContainerLabels struct {
-	Include   string
-	Transform string
+	Include    string
+	Transform  string
+	Strategy   string
+	Policy     string
+	Constraint string
+	// Platform updates must be available for, like linux/arm64
+	Platform string
+	// Include and Transform compiled when the labels are read
+	IncludeRegex   *regexp.Regexp
+	TransformRegex *regexp.Regexp
+	Replacement    string
+	// Set when a label can't be used, the regexes are nil then
+	Err error
 }
 
-type ConfigFileReaderFn = func(*CommandFlags) []byte
+type ConfigFileReaderFn = func(*CommandFlags) ([]byte, error)
+
+type ContainerDiscoveryFn = func(Config) ([]Container, error)
+
+type RegistryTagFetcherFn = func(string, *tls.Config, AuthType, string, string, *TagList) int
 
-type ContainerDiscoveryFn = func(Config) []Container
+type RegistryDigestFetcherFn = func(string, *tls.Config, AuthType, string, string, string) (string, int)
 
-type RegistryTagFetcherFn = func(string, AuthType, string, string, *TagList, string) int
+type RegistryPlatformFetcherFn = func(string, *tls.Config, AuthType, string, string, string) ([]string, int)
+
+// Returns whether a tag of <domain>/<path> is available for a platform
+type PlatformCheckFn = func(string, string, string, string) bool
 
 type GroupedRepository struct {
 	// AuthType  AuthType
 	// AuthToken string
 	Domain string
 	Paths  []string
+	// Floating tags per path that need their manifest digest fetched
+	DigestTags map[string][]string
 }
 
 type Registry interface {
-	GetAuth(GroupedRepository, AuthType, string) (string, AuthType)
+	GetAuth(GroupedRepository, AuthType, string, *tls.Config) (string, AuthType, error)
 	GetUrl() string
 }
 
 type TagList struct {
 	Tags []string
+	// Sent as If-None-Match when set, and replaced by the ETag of the response
+	ETag string
+	// Set when the registry answered 304 Not Modified, Tags is left empty
+	NotModified bool
+	// Number of tags to ask for per page, the registry default when zero
+	PageSize int
+	// Maximum number of pages to fetch, unlimited when zero
+	MaxPages int
+	// Set when MaxPages was reached before the last page
+	Truncated bool
+	// Url of the registry or mirror the ETag belongs to
+	Source string
 }
 
 type TrackedContainers = []TrackedContainer
@@ -92,8 +269,45 @@ type TrackedContainers = []TrackedContainer
 type DomainGroupedRepoMap = map[string]GroupedRepository
 
 type ImageTags struct {
-	Status int
-	Tags   []string
+	Status   int
+	Err      error
+	Tags     []string
+	Digests  map[string]string
+	Duration time.Duration
 }
 
 type ImageTagMap = map[string]ImageTags
+
+// Version of the Report schema, bump on incompatible changes
+const ReportSchemaVersion = 1
+
+type ResultError struct {
+	Code           string `json:"code" yaml:"code"`
+	Message        string `json:"message" yaml:"message"`
+	RegistryStatus int    `json:"registryStatus,omitempty" yaml:"registryStatus,omitempty"`
+}
+
+type ContainerResult struct {
+	Container    string       `json:"container" yaml:"container"`
+	Host         string       `json:"host,omitempty" yaml:"host,omitempty"`
+	Status       string       `json:"status" yaml:"status"`
+	Detail       string       `json:"detail" yaml:"detail"`
+	Repository   string       `json:"repository" yaml:"repository"`
+	Image        string       `json:"image" yaml:"image"`
+	Domain       string       `json:"domain" yaml:"domain"`
+	Path         string       `json:"path" yaml:"path"`
+	Tag          string       `json:"tag" yaml:"tag"`
+	Update       string       `json:"update" yaml:"update"`
+	Latest       string       `json:"latest,omitempty" yaml:"latest,omitempty"`
+	Platform     string       `json:"platform,omitempty" yaml:"platform,omitempty"`
+	Digest       string       `json:"digest,omitempty" yaml:"digest,omitempty"`
+	RemoteDigest string       `json:"remoteDigest,omitempty" yaml:"remoteDigest,omitempty"`
+	New          bool         `json:"new,omitempty" yaml:"new,omitempty"`
+	UpdateSince  *time.Time   `json:"updateSince,omitempty" yaml:"updateSince,omitempty"`
+	Error        *ResultError `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type Report struct {
+	SchemaVersion int               `json:"schemaVersion" yaml:"schemaVersion"`
+	Containers    []ContainerResult `json:"containers" yaml:"containers"`
+}
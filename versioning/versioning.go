@@ -0,0 +1,317 @@
+package versioning
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+type Version interface {
+	// Compares with a version parsed by the same strategy, returning -1, 0 or 1
+	Compare(Version) int
+	String() string
+}
+
+type Strategy interface {
+	Name() string
+	Parse(tag string) (Version, error)
+}
+
+// Available strategies, date takes a Go time layout as date:<layout>
+var Strategies = []string{"semver", "calver", "numeric", "date", "lexical"}
+
+// Creates a strategy from a contrack.strategy label value, empty is semver
+func NewStrategy(spec string) (Strategy, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch name {
+	case "", "semver":
+		return SemVer{}, nil
+	case "calver":
+		return CalVer{}, nil
+	case "numeric":
+		return Numeric{}, nil
+	case "date":
+		if arg == "" {
+			arg = "20060102"
+		}
+		return Date{Layout: arg}, nil
+	case "lexical":
+		return Lexical{}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q, expected one of %s", spec, strings.Join(Strategies, ", "))
+}
+
+// Returns whether candidate is newer than local. SemVer pre-releases are only
+// updates for pre-release versions. Everything is newer than a nil local.
+func IsUpdate(local Version, candidate Version) bool {
+	if v, ok := candidate.(semVerVersion); ok && v.Prerelease() != "" {
+		if l, ok := local.(semVerVersion); ok && l.Prerelease() == "" {
+			return false
+		}
+	}
+	return local == nil || candidate.Compare(local) > 0
+}
+
+func compareInts(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Semantic versioning, leniently parsed so 3.20 and v1 are accepted
+type SemVer struct{}
+
+type semVerVersion struct {
+	*semver.Version
+}
+
+func (s SemVer) Name() string {
+	return "semver"
+}
+
+func (s SemVer) Parse(tag string) (Version, error) {
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		return nil, err
+	}
+	return semVerVersion{v}, nil
+}
+
+func (v semVerVersion) Compare(other Version) int {
+	return v.Version.Compare(other.(semVerVersion).Version)
+}
+
+// Calendar versioning like 2024.10.1 or 24.04, compared numerically part by part
+type CalVer struct{}
+
+type calVerVersion struct {
+	tag   string
+	parts []int64
+}
+
+var calVerRegex = regexp.MustCompile(`^v?(\d+)((?:\.\d+)+)$`)
+
+func (s CalVer) Name() string {
+	return "calver"
+}
+
+func (s CalVer) Parse(tag string) (Version, error) {
+	if !calVerRegex.MatchString(tag) {
+		return nil, fmt.Errorf("%q is not a calendar version", tag)
+	}
+	parts := []int64{}
+	for _, part := range strings.Split(strings.TrimPrefix(tag, "v"), ".") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, n)
+	}
+	return calVerVersion{tag: tag, parts: parts}, nil
+}
+
+func (v calVerVersion) Compare(other Version) int {
+	o := other.(calVerVersion)
+	for i := 0; i < max(len(v.parts), len(o.parts)); i++ {
+		// Missing parts count as zero, so 2024.10 equals 2024.10.0
+		var a, b int64
+		if i < len(v.parts) {
+			a = v.parts[i]
+		}
+		if i < len(o.parts) {
+			b = o.parts[i]
+		}
+		if c := compareInts(a, b); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (v calVerVersion) String() string {
+	return v.tag
+}
+
+// A single number, optionally with a prefix or suffix, like 20241018 or r1234
+type Numeric struct{}
+
+type numericVersion struct {
+	tag    string
+	digits string
+}
+
+var numericRegex = regexp.MustCompile(`^\D*(\d+)\D*$`)
+
+func (s Numeric) Name() string {
+	return "numeric"
+}
+
+func (s Numeric) Parse(tag string) (Version, error) {
+	match := numericRegex.FindStringSubmatch(tag)
+	if match == nil {
+		return nil, fmt.Errorf("%q does not contain exactly one number", tag)
+	}
+	digits := strings.TrimLeft(match[1], "0")
+	return numericVersion{tag: tag, digits: digits}, nil
+}
+
+func (v numericVersion) Compare(other Version) int {
+	o := other.(numericVersion)
+	// Compared as strings so numbers of any length work
+	if c := compareInts(int64(len(v.digits)), int64(len(o.digits))); c != 0 {
+		return c
+	}
+	return strings.Compare(v.digits, o.digits)
+}
+
+func (v numericVersion) String() string {
+	return v.tag
+}
+
+// A timestamp in a Go time layout, like RELEASE.2006-01-02T15-04-05Z
+type Date struct {
+	Layout string
+}
+
+type dateVersion struct {
+	tag  string
+	time time.Time
+}
+
+func (s Date) Name() string {
+	return fmt.Sprintf("date (%s)", s.Layout)
+}
+
+func (s Date) Parse(tag string) (Version, error) {
+	t, err := time.Parse(s.Layout, tag)
+	if err != nil {
+		return nil, err
+	}
+	return dateVersion{tag: tag, time: t}, nil
+}
+
+func (v dateVersion) Compare(other Version) int {
+	return v.time.Compare(other.(dateVersion).time)
+}
+
+func (v dateVersion) String() string {
+	return v.tag
+}
+
+// Plain string ordering, every tag is valid
+type Lexical struct{}
+
+type lexicalVersion string
+
+func (s Lexical) Name() string {
+	return "lexical"
+}
+
+func (s Lexical) Parse(tag string) (Version, error) {
+	return lexicalVersion(tag), nil
+}
+
+func (v lexicalVersion) Compare(other Version) int {
+	return strings.Compare(string(v), string(other.(lexicalVersion)))
+}
+
+func (v lexicalVersion) String() string {
+	return string(v)
+}
+
+// Versions that have major, minor and patch like segments
+type segmented interface {
+	segments() []int64
+}
+
+func (v semVerVersion) segments() []int64 {
+	return []int64{v.Major(), v.Minor(), v.Patch()}
+}
+
+func (v calVerVersion) segments() []int64 {
+	return v.parts
+}
+
+// Available update levels for contrack.policy
+var PolicyLevels = []string{"any", "major", "minor", "patch"}
+
+// Restricts which updates are accepted, by level and/or a semver constraint
+type Policy struct {
+	level      string
+	constraint *semver.Constraints
+}
+
+// Creates a policy from contrack.policy and contrack.constraint label values
+func NewPolicy(level string, constraint string, strategy Strategy) (Policy, error) {
+	policy := Policy{level: strings.TrimSpace(level)}
+	if policy.level == "" {
+		policy.level = "any"
+	}
+	if !slices.Contains(PolicyLevels, policy.level) {
+		return Policy{}, fmt.Errorf("unknown policy %q, expected one of %s", level, strings.Join(PolicyLevels, ", "))
+	}
+	switch strategy.(type) {
+	case SemVer, CalVer:
+	default:
+		if policy.level != "any" {
+			return Policy{}, fmt.Errorf("policy %s needs the semver or calver strategy", policy.level)
+		}
+	}
+
+	if constraint != "" {
+		if _, ok := strategy.(SemVer); !ok {
+			return Policy{}, fmt.Errorf("constraints need the semver strategy")
+		}
+		c, err := semver.NewConstraint(constraint)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid constraint %q: %w", constraint, err)
+		}
+		policy.constraint = c
+	}
+	return policy, nil
+}
+
+// Returns whether the policy accepts candidate as an update for local
+func (p Policy) Allows(local Version, candidate Version) bool {
+	if p.constraint != nil && !p.constraint.Check(candidate.(semVerVersion).Version) {
+		return false
+	}
+
+	// Without a readable local version there is nothing to stay close to
+	l, lok := local.(segmented)
+	c, cok := candidate.(segmented)
+	if !lok || !cok {
+		return true
+	}
+	// Number of leading segments that must stay the same
+	fixed := 0
+	switch p.level {
+	case "minor":
+		fixed = 1
+	case "patch":
+		fixed = 2
+	}
+	ls, cs := l.segments(), c.segments()
+	for i := 0; i < fixed; i++ {
+		var a, b int64
+		if i < len(ls) {
+			a = ls[i]
+		}
+		if i < len(cs) {
+			b = cs[i]
+		}
+		if a != b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package versioning
+
+import "testing"
+
+func mustParse(t *testing.T, strategy Strategy, tag string) Version {
+	t.Helper()
+	version, err := strategy.Parse(tag)
+	if err != nil {
+		t.Fatalf("%s: parsing %q: %v", strategy.Name(), tag, err)
+	}
+	return version
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := []struct {
+		spec string
+		name string
+	}{
+		{spec: "", name: "semver"},
+		{spec: "semver", name: "semver"},
+		{spec: " calver ", name: "calver"},
+		{spec: "numeric", name: "numeric"},
+		{spec: "date", name: "date (20060102)"},
+		{spec: "date:2006-01-02", name: "date (2006-01-02)"},
+		{spec: "lexical", name: "lexical"},
+	}
+	for _, test := range tests {
+		strategy, err := NewStrategy(test.spec)
+		if err != nil {
+			t.Errorf("NewStrategy(%q) returned error: %v", test.spec, err)
+			continue
+		}
+		if got := strategy.Name(); got != test.name {
+			t.Errorf("NewStrategy(%q).Name() = %q, want %q", test.spec, got, test.name)
+		}
+	}
+
+	if _, err := NewStrategy("alphabetical"); err == nil {
+		t.Error("NewStrategy with an unknown name returned no error")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		strategy Strategy
+		a        string
+		b        string
+		want     int
+	}{
+		{strategy: SemVer{}, a: "1.2.3", b: "1.2.4", want: -1},
+		{strategy: SemVer{}, a: "v1", b: "1.0.0", want: 0},
+		{strategy: SemVer{}, a: "3.20", b: "3.9", want: 1},
+		{strategy: SemVer{}, a: "1.0.0-rc.1", b: "1.0.0", want: -1},
+		// Missing parts count as zero
+		{strategy: CalVer{}, a: "2024.10", b: "2024.10.0", want: 0},
+		{strategy: CalVer{}, a: "2024.10.1", b: "2024.9.30", want: 1},
+		{strategy: CalVer{}, a: "24.04", b: "24.10", want: -1},
+		{strategy: CalVer{}, a: "v2024.1", b: "2024.1", want: 0},
+		{strategy: CalVer{}, a: "2024.10", b: "2024.10.0.1", want: -1},
+		{strategy: Numeric{}, a: "20241018", b: "20241019", want: -1},
+		{strategy: Numeric{}, a: "r1234", b: "r999", want: 1},
+		// Leading zeros don't count towards the length
+		{strategy: Numeric{}, a: "r0099", b: "r100", want: -1},
+		{strategy: Numeric{}, a: "007", b: "7", want: 0},
+		{strategy: Numeric{}, a: "build-123456789012345678901234567890", b: "build-9", want: 1},
+		{strategy: Date{Layout: "20060102"}, a: "20241018", b: "20240930", want: 1},
+		{strategy: Date{Layout: "RELEASE.2006-01-02T15-04-05Z"}, a: "RELEASE.2024-10-18T10-00-00Z", b: "RELEASE.2024-10-18T09-59-59Z", want: 1},
+		{strategy: Date{Layout: "2006-01-02"}, a: "2024-01-02", b: "2024-01-02", want: 0},
+		{strategy: Lexical{}, a: "alpha", b: "beta", want: -1},
+		{strategy: Lexical{}, a: "b", b: "a10", want: 1},
+	}
+	for _, test := range tests {
+		a := mustParse(t, test.strategy, test.a)
+		b := mustParse(t, test.strategy, test.b)
+		if got := a.Compare(b); got != test.want {
+			t.Errorf("%s: %q compared to %q = %d, want %d", test.strategy.Name(), test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		strategy Strategy
+		tag      string
+	}{
+		{strategy: SemVer{}, tag: "latest"},
+		{strategy: CalVer{}, tag: "2024"},
+		{strategy: CalVer{}, tag: "2024.10-alpine"},
+		{strategy: CalVer{}, tag: "2024..10"},
+		{strategy: Numeric{}, tag: "latest"},
+		{strategy: Numeric{}, tag: "1.2"},
+		{strategy: Date{Layout: "20060102"}, tag: "20241318"},
+		{strategy: Date{Layout: "2006-01-02"}, tag: "20241018"},
+	}
+	for _, test := range tests {
+		if _, err := test.strategy.Parse(test.tag); err == nil {
+			t.Errorf("%s: parsing %q returned no error", test.strategy.Name(), test.tag)
+		}
+	}
+
+	if _, err := (Lexical{}).Parse("anything goes"); err != nil {
+		t.Errorf("lexical: parsing returned error: %v", err)
+	}
+}
+
+func TestIsUpdate(t *testing.T) {
+	tests := []struct {
+		strategy  Strategy
+		local     string
+		candidate string
+		want      bool
+	}{
+		{strategy: SemVer{}, local: "1.0.0", candidate: "1.0.1", want: true},
+		{strategy: SemVer{}, local: "1.0.1", candidate: "1.0.0", want: false},
+		{strategy: SemVer{}, local: "1.0.0", candidate: "1.0.0", want: false},
+		// Pre-releases are only updates for pre-releases
+		{strategy: SemVer{}, local: "1.0.0", candidate: "1.1.0-rc.1", want: false},
+		{strategy: SemVer{}, local: "1.1.0-rc.1", candidate: "1.1.0-rc.2", want: true},
+		{strategy: SemVer{}, local: "1.1.0-rc.1", candidate: "1.1.0", want: true},
+		{strategy: CalVer{}, local: "2024.10", candidate: "2024.10.0", want: false},
+		{strategy: CalVer{}, local: "2024.10", candidate: "2024.10.1", want: true},
+		{strategy: Numeric{}, local: "r999", candidate: "r1000", want: true},
+	}
+	for _, test := range tests {
+		local := mustParse(t, test.strategy, test.local)
+		candidate := mustParse(t, test.strategy, test.candidate)
+		if got := IsUpdate(local, candidate); got != test.want {
+			t.Errorf("%s: IsUpdate(%q, %q) = %v, want %v", test.strategy.Name(), test.local, test.candidate, got, test.want)
+		}
+	}
+
+	// Everything is newer than an unreadable local version
+	if !IsUpdate(nil, mustParse(t, SemVer{}, "1.0.0-rc.1")) {
+		t.Error("IsUpdate(nil, 1.0.0-rc.1) = false, want true")
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	tests := []struct {
+		level      string
+		constraint string
+		strategy   Strategy
+	}{
+		{level: "newest", strategy: SemVer{}},
+		{level: "minor", strategy: Numeric{}},
+		{level: "patch", strategy: Date{Layout: "20060102"}},
+		{level: "major", strategy: Lexical{}},
+		{constraint: "~2024.10", strategy: CalVer{}},
+		{constraint: ">>1", strategy: SemVer{}},
+	}
+	for _, test := range tests {
+		if _, err := NewPolicy(test.level, test.constraint, test.strategy); err == nil {
+			t.Errorf("NewPolicy(%q, %q, %s) returned no error", test.level, test.constraint, test.strategy.Name())
+		}
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	tests := []struct {
+		level      string
+		constraint string
+		strategy   Strategy
+		local      string
+		candidate  string
+		want       bool
+	}{
+		{level: "", strategy: SemVer{}, local: "1.4.2", candidate: "2.0.0", want: true},
+		{level: "any", strategy: SemVer{}, local: "1.4.2", candidate: "2.0.0", want: true},
+		{level: "major", strategy: SemVer{}, local: "1.4.2", candidate: "2.0.0", want: true},
+		{level: "minor", strategy: SemVer{}, local: "1.4.2", candidate: "1.5.0", want: true},
+		{level: "minor", strategy: SemVer{}, local: "1.4.2", candidate: "2.0.0", want: false},
+		{level: "patch", strategy: SemVer{}, local: "1.4.2", candidate: "1.4.3", want: true},
+		{level: "patch", strategy: SemVer{}, local: "1.4.2", candidate: "1.5.0", want: false},
+		{level: "patch", strategy: SemVer{}, local: "3.20", candidate: "3.20.1", want: true},
+		{constraint: "~1.4", strategy: SemVer{}, local: "1.4.2", candidate: "1.4.9", want: true},
+		{constraint: "~1.4", strategy: SemVer{}, local: "1.4.2", candidate: "1.5.0", want: false},
+		{level: "major", constraint: "<2.0.0", strategy: SemVer{}, local: "1.4.2", candidate: "2.0.0", want: false},
+		{level: "patch", constraint: ">=1.4.3", strategy: SemVer{}, local: "1.4.2", candidate: "1.4.3", want: true},
+		{level: "minor", strategy: CalVer{}, local: "2024.10.1", candidate: "2024.11.0", want: true},
+		{level: "minor", strategy: CalVer{}, local: "2024.10.1", candidate: "2025.1.0", want: false},
+		{level: "patch", strategy: CalVer{}, local: "2024.10.1", candidate: "2024.10.2", want: true},
+		{level: "patch", strategy: CalVer{}, local: "2024.10.1", candidate: "2024.11.0", want: false},
+		// Missing parts count as zero
+		{level: "patch", strategy: CalVer{}, local: "2024.10", candidate: "2024.10.1", want: true},
+		{level: "any", strategy: Numeric{}, local: "r999", candidate: "r1000", want: true},
+	}
+	for _, test := range tests {
+		policy, err := NewPolicy(test.level, test.constraint, test.strategy)
+		if err != nil {
+			t.Errorf("NewPolicy(%q, %q, %s) returned error: %v", test.level, test.constraint, test.strategy.Name(), err)
+			continue
+		}
+		local := mustParse(t, test.strategy, test.local)
+		candidate := mustParse(t, test.strategy, test.candidate)
+		if got := policy.Allows(local, candidate); got != test.want {
+			t.Errorf("policy %q %q: Allows(%q, %q) = %v, want %v", test.level, test.constraint, test.local, test.candidate, got, test.want)
+		}
+	}
+
+	// Without a readable local version only the constraint applies
+	policy, err := NewPolicy("patch", "<2.0.0", SemVer{})
+	if err != nil {
+		t.Fatalf("NewPolicy returned error: %v", err)
+	}
+	if !policy.Allows(nil, mustParse(t, SemVer{}, "1.9.0")) {
+		t.Error("Allows(nil, 1.9.0) = false, want true")
+	}
+	if policy.Allows(nil, mustParse(t, SemVer{}, "2.0.0")) {
+		t.Error("Allows(nil, 2.0.0) = true, want false")
+	}
+}
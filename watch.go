@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mlofjard/contrack/metrics"
+	"github.com/mlofjard/contrack/notify"
+	"github.com/mlofjard/contrack/schedule"
+	"github.com/mlofjard/contrack/state"
+	. "github.com/mlofjard/contrack/types"
+)
+
+// Describes how a container result changed since the previous run, empty if unchanged
+func describeChange(previous *ContainerResult, current ContainerResult) string {
+	if previous == nil {
+		switch {
+		case current.Status != "OK":
+			return "error: " + current.Detail
+		case current.Update != "":
+			return "update available: " + current.Tag + " -> " + current.Update
+		}
+		return ""
+	}
+
+	switch {
+	case previous.Status != current.Status && current.Status != "OK":
+		return "error: " + current.Detail
+	case previous.Status != current.Status:
+		return "recovered"
+	case previous.Update != current.Update && current.Update == "":
+		return "up to date: " + current.Tag
+	case previous.Update != current.Update:
+		return "update available: " + current.Tag + " -> " + current.Update
+	case previous.Tag != current.Tag:
+		return "now running: " + current.Tag
+	}
+	return ""
+}
+
+// Identifies a container across hosts, like host/container
+func containerKey(result ContainerResult) string {
+	if result.Host == "" {
+		return result.Container
+	}
+	return result.Host + "/" + result.Container
+}
+
+// Logs changes and returns the results mapped by container
+func logChanges(previous map[string]ContainerResult, results []ContainerResult) map[string]ContainerResult {
+	current := make(map[string]ContainerResult, len(results))
+	for _, result := range results {
+		key := containerKey(result)
+		current[key] = result
+
+		var previousResult *ContainerResult
+		if p, ok := previous[key]; ok {
+			previousResult = &p
+		}
+		if change := describeChange(previousResult, result); change != "" {
+			log.Printf("%s (%s) %s", key, result.Repository, change)
+		}
+	}
+	for key, result := range previous {
+		if _, ok := current[key]; !ok {
+			log.Printf("%s (%s) removed", key, result.Repository)
+		}
+	}
+	return current
+}
+
+// Serves /metrics on the address until the context is done
+func serveMetrics(ctx context.Context, address string) *metrics.Server {
+	metricsServer := &metrics.Server{}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsServer)
+	server := &http.Server{Addr: address, Handler: mux}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Error serving metrics: %v", err)
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error serving metrics: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Printf("Serving metrics on %s/metrics", listener.Addr())
+	return metricsServer
+}
+
+// Keeps running the pipeline on schedule until the context is done. A value
+// on trigger runs the pipeline right away, onRun is called after every run.
+func watch(ctx context.Context, config Config, domainConfiguredRegistryMap DomainConfiguredRegistryMap, fns pipelineFns, updateState *state.State, trigger <-chan struct{}, onRun func(pipelineRun)) {
+	sched, _ := schedule.New(config.Interval, config.Cron)
+
+	var metricsServer *metrics.Server
+	if config.MetricsListen != "" {
+		metricsServer = serveMetrics(ctx, config.MetricsListen)
+	}
+
+	var previous map[string]ContainerResult
+	check := func() {
+		run, err := runPipeline(config, domainConfiguredRegistryMap, fns)
+		if err != nil {
+			// Discovery failures are retried on the next check instead of stopping
+			log.Printf("Check failed: %v", err)
+			return
+		}
+		results := run.results
+		if previous == nil {
+			log.Printf("Tracking %d containers", len(results))
+		}
+		previous = logChanges(previous, results)
+
+		// Only updates not seen in an earlier run, or before a restart with a state file, are notified
+		updateState.Apply(results, time.Now())
+		notify.Notify(config, results)
+		if err := updateState.Save(); err != nil {
+			log.Printf("Error saving state: %v", err)
+		}
+		if metricsServer != nil {
+			metricsServer.Update(run.metrics())
+		}
+		if config.MetricsFile != "" {
+			if err := metrics.WriteTextfile(config.MetricsFile, run.metrics()); err != nil {
+				log.Printf("Error writing metrics: %v", err)
+			}
+		}
+		if onRun != nil {
+			onRun(run)
+		}
+	}
+
+	for {
+		check()
+
+		next := sched.Next(time.Now())
+		if config.Debug {
+			log.Printf("Next check at %s", next.Format(time.RFC3339))
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Printf("Shutting down")
+			return
+		case <-trigger:
+			timer.Stop()
+			log.Printf("Check requested")
+		case <-timer.C:
+		}
+	}
+}